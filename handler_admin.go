@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tcluri/chirpy/internal/auth"
+	"github.com/tcluri/chirpy/internal/database"
+)
+
+var errAPIKeyMismatch = errors.New("API key mismatch")
+
+// middlewareRequireRole lets a request through only if it comes from a
+// user who currently has one of the given roles, with their principal in
+// the request context. The ADMIN_KEY, sent as an API key, counts as an
+// admin, so that the first admin can be appointed.
+func (cfg *apiConfig) middlewareRequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var principal Principal
+			if strings.HasPrefix(r.Header.Get("Authorization"), "ApiKey ") {
+				apiKey, err := auth.GetAPIKey(r.Header)
+				if err != nil || cfg.adminKey == "" || subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.adminKey)) != 1 {
+					respondUnauthorized(w, errAPIKeyMismatch)
+					return
+				}
+				principal = Principal{Role: database.RoleAdmin}
+			} else {
+				var err error
+				principal, err = cfg.authenticate(r)
+				if err != nil {
+					respondAuthError(w, err)
+					return
+				}
+			}
+			for _, allowed := range roles {
+				if principal.Role == allowed {
+					next.ServeHTTP(w, r.WithContext(contextWithPrincipal(r.Context(), principal)))
+					return
+				}
+			}
+			respondWithError(w, http.StatusForbidden, "Not allowed for your role")
+		})
+	}
+}
+
+// roleRank orders the roles by how much they are allowed to do.
+func roleRank(role string) int {
+	switch role {
+	case database.RoleAdmin:
+		return 2
+	case database.RoleModerator:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (cfg *apiConfig) handlerAdminReports(w http.ResponseWriter, r *http.Request) {
+	resolved := false
+	switch r.URL.Query().Get("status") {
+	case "", "open":
+	case "resolved":
+		resolved = true
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid status")
+		return
+	}
+
+	dbReports, err := cfg.DB.GetReports(resolved)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve reports")
+		return
+	}
+
+	reports := []Report{}
+	for _, dbReport := range dbReports {
+		reports = append(reports, reportFromDB(dbReport))
+	}
+	respondWithJSON(w, http.StatusOK, reports)
+}
+
+func (cfg *apiConfig) handlerAdminReportsResolve(w http.ResponseWriter, r *http.Request) {
+	reportIDString := chi.URLParam(r, "reportID")
+	reportID, err := strconv.Atoi(reportIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid report ID")
+		return
+	}
+
+	report, err := cfg.DB.ResolveReport(reportID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't resolve report")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, reportFromDB(report))
+}
+
+func (cfg *apiConfig) handlerAdminChirpsHide(w http.ResponseWriter, r *http.Request) {
+	chirpIDString := chi.URLParam(r, "chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	dbChirp, err := cfg.DB.HideChirp(chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't hide chirp")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirpFromDB(dbChirp))
+}
+
+func (cfg *apiConfig) handlerAdminChirpsUnhide(w http.ResponseWriter, r *http.Request) {
+	chirpIDString := chi.URLParam(r, "chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	dbChirp, err := cfg.DB.UnhideChirp(chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't unhide chirp")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirpFromDB(dbChirp))
+}
+
+func (cfg *apiConfig) handlerAdminUsersSuspend(w http.ResponseWriter, r *http.Request) {
+	userIDString := chi.URLParam(r, "userID")
+	userID, err := strconv.Atoi(userIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	target, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user")
+		return
+	}
+	// Moderators can't suspend each other, nor admins
+	principal, _ := principalFromContext(r.Context())
+	if roleRank(target.Role) >= roleRank(principal.Role) {
+		respondWithError(w, http.StatusForbidden, "Can't suspend a user whose role is not below yours")
+		return
+	}
+
+	user, err := cfg.DB.SuspendUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't suspend user")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, userFromDB(user))
+}
+
+func (cfg *apiConfig) handlerAdminUsersUnsuspend(w http.ResponseWriter, r *http.Request) {
+	userIDString := chi.URLParam(r, "userID")
+	userID, err := strconv.Atoi(userIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	user, err := cfg.DB.UnsuspendUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't unsuspend user")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, userFromDB(user))
+}
+
+func (cfg *apiConfig) handlerAdminUsersRole(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	userIDString := chi.URLParam(r, "userID")
+	userID, err := strconv.Atoi(userIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+	switch params.Role {
+	case database.RoleUser, database.RoleModerator, database.RoleAdmin:
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid role")
+		return
+	}
+
+	user, err := cfg.DB.SetUserRole(userID, params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't set role")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, userFromDB(user))
+}
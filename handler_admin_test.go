@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tcluri/chirpy/internal/database"
+)
+
+// signUp creates a user with the given role and logs them in, returning
+// their ID and access token.
+func signUp(t *testing.T, srv *httptest.Server, email, role string) (int, string) {
+	t.Helper()
+	credentials := map[string]string{"email": email, "password": "password"}
+	user := User{}
+	err := expectStatus(http.StatusCreated, srv, "POST", "/api/users", "", credentials, &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != database.RoleUser {
+		err = expectStatus(http.StatusOK, srv, "PUT", fmt.Sprintf("/admin/users/%d/role", user.ID),
+			"ApiKey "+testAdminKey, map[string]string{"role": role}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	login := struct {
+		Token string `json:"token"`
+	}{}
+	err = expectStatus(http.StatusOK, srv, "POST", "/api/login", "", credentials, &login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID, login.Token
+}
+
+func TestSuspendNeedsHigherRole(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, srv *httptest.Server) {
+		adminID, adminToken := signUp(t, srv, "admin@example.com", database.RoleAdmin)
+		modID, modToken := signUp(t, srv, "mod@example.com", database.RoleModerator)
+		otherModID, _ := signUp(t, srv, "othermod@example.com", database.RoleModerator)
+		userID, _ := signUp(t, srv, "user@example.com", database.RoleUser)
+		apiKey := "ApiKey " + testAdminKey
+
+		tests := []struct {
+			name   string
+			token  string
+			target int
+			want   int
+		}{
+			{"moderator suspends admin", modToken, adminID, http.StatusForbidden},
+			{"moderator suspends moderator", modToken, otherModID, http.StatusForbidden},
+			{"moderator suspends self", modToken, modID, http.StatusForbidden},
+			{"admin suspends self", adminToken, adminID, http.StatusForbidden},
+			{"admin key suspends admin", apiKey, adminID, http.StatusForbidden},
+			{"moderator suspends user", modToken, userID, http.StatusOK},
+			{"admin suspends moderator", adminToken, otherModID, http.StatusOK},
+			{"admin key suspends moderator", apiKey, modID, http.StatusOK},
+			{"missing user", apiKey, userID + 100, http.StatusNotFound},
+		}
+		for _, tt := range tests {
+			path := fmt.Sprintf("/admin/users/%d/suspend", tt.target)
+			if err := expectStatus(tt.want, srv, "POST", path, tt.token, nil, nil); err != nil {
+				t.Errorf("%s: %s", tt.name, err)
+			}
+		}
+	})
+}
+
+func TestSuspendedUserIsRefused(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, srv *httptest.Server) {
+		userID, token := signUp(t, srv, "user@example.com", database.RoleUser)
+		apiKey := "ApiKey " + testAdminKey
+		path := fmt.Sprintf("/admin/users/%d/suspend", userID)
+		if err := expectStatus(http.StatusOK, srv, "POST", path, apiKey, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		chirp := map[string]string{"body": "hello"}
+		if err := expectStatus(http.StatusForbidden, srv, "POST", "/api/chirps", token, chirp, nil); err != nil {
+			t.Error(err)
+		}
+		if err := expectStatus(http.StatusForbidden, srv, "GET", "/api/timeline", token, nil, nil); err != nil {
+			t.Error(err)
+		}
+
+		// Lifting the suspension doesn't bring the old token back
+		if err := expectStatus(http.StatusOK, srv, "DELETE", path, apiKey, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := expectStatus(http.StatusUnauthorized, srv, "POST", "/api/chirps", token, chirp, nil); err != nil {
+			t.Error(err)
+		}
+	})
+}
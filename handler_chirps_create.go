@@ -3,94 +3,183 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"log"
 	"net/http"
-	"strconv"
 	"strings"
+	"time"
 
-	"github.com/tcluri/chirpy/internal/auth"
+	"github.com/tcluri/chirpy/internal/database"
+	"github.com/tcluri/chirpy/internal/moderation"
 )
 
+// Author is the compact view of a user shown with each of their chirps.
+type Author struct {
+	ID          int    `json:"id"`
+	Handle      string `json:"handle,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
 type Chirp struct {
-	ID       int    `json:"id"`
-	AuthorID int    `json:"author_id"`
-	Body     string `json:"body"`
+	ID         int       `json:"id"`
+	AuthorID   int       `json:"author_id"`
+	Author     Author    `json:"author"`
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	ReplyTo    *int      `json:"reply_to,omitempty"`
+	ReplyCount int       `json:"reply_count"`
+	LikeCount  int       `json:"like_count"`
+	RechirpOf  *int      `json:"rechirp_of,omitempty"`
+	Original   *Chirp    `json:"original,omitempty"`
+	Entities   []Entity  `json:"entities,omitempty"`
+	// HiddenAt is only ever shown to admins
+	HiddenAt *time.Time `json:"hidden_at,omitempty"`
+}
+
+// Entity is a hashtag or mention in a chirp's body, located by byte
+// offsets.
+type Entity struct {
+	Type   string `json:"type"`
+	Text   string `json:"text"`
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+	UserID int    `json:"user_id,omitempty"`
+}
+
+func chirpFromDB(dbChirp database.Chirp) Chirp {
+	chirp := Chirp{
+		ID:         dbChirp.ID,
+		AuthorID:   dbChirp.AuthorID,
+		Body:       dbChirp.Body,
+		CreatedAt:  dbChirp.CreatedAt,
+		UpdatedAt:  dbChirp.UpdatedAt,
+		ReplyTo:    dbChirp.ReplyTo,
+		ReplyCount: dbChirp.ReplyCount,
+		LikeCount:  dbChirp.LikeCount,
+		RechirpOf:  dbChirp.RechirpOf,
+		HiddenAt:   dbChirp.HiddenAt,
+		Author: Author{
+			ID:          dbChirp.Author.ID,
+			Handle:      dbChirp.Author.Handle,
+			DisplayName: dbChirp.Author.DisplayName,
+			AvatarURL:   dbChirp.Author.AvatarURL,
+		},
+	}
+	for _, entity := range dbChirp.Entities {
+		chirp.Entities = append(chirp.Entities, Entity{
+			Type:   entity.Type,
+			Text:   entity.Text,
+			Start:  entity.Start,
+			End:    entity.End,
+			UserID: entity.UserID,
+		})
+	}
+	if dbChirp.Original != nil {
+		original := chirpFromDB(*dbChirp.Original)
+		chirp.Original = &original
+	}
+	return chirp
 }
 
 func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Body string `json:"body"`
+		Body      string `json:"body"`
+		ReplyTo   *int   `json:"reply_to"`
+		RechirpOf *int   `json:"rechirp_of"`
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	principal, _ := principalFromContext(r.Context())
+	userID := principal.UserID
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
 		return
 	}
-	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+
+	if params.ReplyTo != nil && params.RechirpOf != nil {
+		respondWithError(w, http.StatusBadRequest, "Chirp can't be both a reply and a rechirp")
 		return
 	}
-	userID, err := strconv.Atoi(subject)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+
+	// A rechirp without a body reposts the original as it is
+	moderated := moderation.Result{}
+	if params.RechirpOf == nil || params.Body != "" {
+		moderated, err = cfg.validateChirp(params.Body)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	cleaned := moderated.Text
+
+	var chirp database.Chirp
+	switch {
+	case params.ReplyTo != nil:
+		chirp, err = cfg.DB.CreateReply(cleaned, userID, *params.ReplyTo)
+	case params.RechirpOf != nil:
+		chirp, err = cfg.DB.CreateRechirp(cleaned, userID, *params.RechirpOf)
+	default:
+		chirp, err = cfg.DB.CreateChirp(cleaned, userID)
+	}
+	if errors.Is(err, database.ErrReplyParent) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't find the chirp to reply to")
 		return
 	}
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err = decoder.Decode(&params)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+	if errors.Is(err, database.ErrRechirpOriginal) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't find the chirp to rechirp")
 		return
 	}
-
-	cleaned, err := validateChirp(params.Body)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, err.Error())
+	if errors.Is(err, database.ErrAlreadyRechirped) {
+		respondWithError(w, http.StatusConflict, "Chirp already rechirped")
 		return
 	}
-
-	chirp, err := cfg.DB.CreateChirp(cleaned, userID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp")
 		return
 	}
+	cfg.reportFlagged(chirp, moderated)
 
-	respondWithJSON(w, http.StatusCreated, Chirp{
-		ID:       chirp.ID,
-		AuthorID: chirp.AuthorID,
-		Body:     chirp.Body,
-	})
+	respondWithJSON(w, http.StatusCreated, chirpFromDB(chirp))
 }
 
-func validateChirp(body string) (string, error) {
+// validateChirp checks a chirp body and runs it through the moderator,
+// returning the result with the masked body. A body that a reject rule
+// matches is an error.
+func (cfg *apiConfig) validateChirp(body string) (moderation.Result, error) {
 	const maxChripLength = 140
 	if len(body) > maxChripLength {
-		return "", errors.New("Chirp is too long")
+		return moderation.Result{}, errors.New("Chirp is too long")
 	}
 
 	if body == "" {
-		return "", errors.New("Chirp cannot be empty")
+		return moderation.Result{}, errors.New("Chirp cannot be empty")
 	}
 
-	badWords := map[string]struct{}{
-		"kerfuffle": {},
-		"sharbert":  {},
-		"fornax":    {},
+	result := cfg.moderator.Check(body)
+	if result.Rejected() {
+		return moderation.Result{}, errors.New("Chirp contains words that aren't allowed")
 	}
-	cleaned := getCleanedBody(body, badWords)
-	return cleaned, nil
-
+	return result, nil
 }
 
-func getCleanedBody(body string, badWords map[string]struct{}) string {
-	words := strings.Split(body, " ")
-	for i, word := range words {
-		loweredWord := strings.ToLower(word)
-		if _, ok := badWords[loweredWord]; ok {
-			words[i] = "****"
+// reportFlagged puts a chirp that the moderation rules flagged in the
+// report queue. The chirp is already saved, so a failure is only logged.
+func (cfg *apiConfig) reportFlagged(chirp database.Chirp, result moderation.Result) {
+	if !result.Flagged() {
+		return
+	}
+	words := []string{}
+	for _, match := range result.Matches {
+		if match.Action == moderation.ActionFlag {
+			words = append(words, match.Word)
 		}
 	}
-	cleaned := strings.Join(words, " ")
-	return cleaned
+	reason := "Flagged words: " + strings.Join(words, ", ")
+	_, err := cfg.DB.CreateReport(chirp.ID, 0, reason)
+	if err != nil && !errors.Is(err, database.ErrAlreadyReported) {
+		log.Printf("Error reporting flagged chirp %d: %s", chirp.ID, err)
+	}
 }
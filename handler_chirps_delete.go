@@ -5,7 +5,6 @@ import (
 	"strconv"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/tcluri/chirpy/internal/auth"
 )
 
 func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request) {
@@ -16,21 +15,8 @@ func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
-		return
-	}
-	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
-		return
-	}
-	userID, err := strconv.Atoi(subject)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
-		return
-	}
+	principal, _ := principalFromContext(r.Context())
+	userID := principal.UserID
 	err = cfg.DB.DeleteChirp(chirpID, userID)
 	if err != nil {
 		respondWithError(w, http.StatusForbidden, "Couldn't delete chirp")
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
-	"sort"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/tcluri/chirpy/internal/database"
 )
 
+// maxChirpsLimit caps the limit parameter of the endpoints that list
+// chirps.
+const maxChirpsLimit = 100
+
 func (cfg *apiConfig) handlerChirpsGet(w http.ResponseWriter, r *http.Request) {
 	chirpIDString := chi.URLParam(r, "chirpID")
 	chirpID, err := strconv.Atoi(chirpIDString)
@@ -22,61 +29,91 @@ func (cfg *apiConfig) handlerChirpsGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, Chirp{
-		ID:       dbChirp.ID,
-		AuthorID: dbChirp.AuthorID,
-		Body:     dbChirp.Body,
-	})
+	respondWithJSON(w, http.StatusOK, chirpFromDB(dbChirp))
 }
 
 func (cfg *apiConfig) handlerChirpsRetrieve(w http.ResponseWriter, r *http.Request) {
+	query, err := parseChirpQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	sortOrder := r.URL.Query().Get("sort")
+	query.Desc = sortOrder == "desc"
 	authorIDString := r.URL.Query().Get("author_id")
-	authorID := 0
 	if authorIDString != "" {
-		var err error
-		authorID, err = strconv.Atoi(authorIDString)
+		query.AuthorID, err = strconv.Atoi(authorIDString)
 		if err != nil {
 			respondWithError(w, http.StatusBadRequest, "Invalid author ID")
 			return
 		}
 	}
 
-	dbChirps, err := cfg.DB.GetChirps()
+	page, err := cfg.DB.QueryChirps(query)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
 		return
 	}
-	chirps := []Chirp{}
-	for _, dbChirp := range dbChirps {
-		if authorID != 0 {
-			if dbChirp.AuthorID == authorID {
-				chirps = append(chirps, Chirp{
-					ID:       dbChirp.ID,
-					AuthorID: dbChirp.AuthorID,
-					Body:     dbChirp.Body,
-				})
-			} else {
-				continue
-			}
-		} else {
-			chirps = append(chirps, Chirp{
-				ID:       dbChirp.ID,
-				AuthorID: dbChirp.AuthorID,
-				Body:     dbChirp.Body,
-			})
+	respondWithChirpPage(w, r, page)
+}
+
+// parseChirpQuery reads the time range and paging parameters shared by the
+// endpoints that list chirps.
+func parseChirpQuery(r *http.Request) (database.ChirpQuery, error) {
+	query := database.ChirpQuery{}
+	limitString := r.URL.Query().Get("limit")
+	if limitString != "" {
+		limit, err := strconv.Atoi(limitString)
+		if err != nil || limit < 1 {
+			return database.ChirpQuery{}, errors.New("Invalid limit")
+		}
+		if limit > maxChirpsLimit {
+			limit = maxChirpsLimit
+		}
+		query.Limit = limit
+	}
+	sinceString := r.URL.Query().Get("since")
+	if sinceString != "" {
+		since, err := time.Parse(time.RFC3339, sinceString)
+		if err != nil {
+			return database.ChirpQuery{}, errors.New("Invalid since time")
+		}
+		query.Since = since
+	}
+	untilString := r.URL.Query().Get("until")
+	if untilString != "" {
+		until, err := time.Parse(time.RFC3339, untilString)
+		if err != nil {
+			return database.ChirpQuery{}, errors.New("Invalid until time")
+		}
+		query.Until = until
+	}
+	cursorString := r.URL.Query().Get("cursor")
+	if cursorString != "" {
+		cursor, err := database.DecodeChirpCursor(cursorString)
+		if err != nil {
+			return database.ChirpQuery{}, errors.New("Invalid cursor")
 		}
+		query.After = &cursor
 	}
+	return query, nil
+}
 
-	if sortOrder != "desc" {
-		sort.Slice(chirps, func(i, j int) bool {
-			return chirps[i].ID < chirps[j].ID
-		})
-	} else {
-		sort.Slice(chirps, func(i, j int) bool {
-			return chirps[i].ID > chirps[j].ID
-		})
+// respondWithChirpPage writes a page of chirps, with a Link header pointing
+// at the next page when there is one.
+func respondWithChirpPage(w http.ResponseWriter, r *http.Request, page database.ChirpPage) {
+	chirps := []Chirp{}
+	for _, dbChirp := range page.Chirps {
+		chirps = append(chirps, chirpFromDB(dbChirp))
+	}
 
+	if page.Next != nil {
+		// Point at the next page with the same filters and sort order
+		next := *r.URL
+		params := next.Query()
+		params.Set("cursor", page.Next.Encode())
+		next.RawQuery = params.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
 	}
 	respondWithJSON(w, http.StatusOK, chirps)
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (cfg *apiConfig) handlerChirpsLike(w http.ResponseWriter, r *http.Request) {
+	chirpIDString := chi.URLParam(r, "chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	principal, _ := principalFromContext(r.Context())
+	userID := principal.UserID
+
+	dbChirp, err := cfg.DB.LikeChirp(chirpID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't like chirp")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, chirpFromDB(dbChirp))
+}
+
+func (cfg *apiConfig) handlerChirpsUnlike(w http.ResponseWriter, r *http.Request) {
+	chirpIDString := chi.URLParam(r, "chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	principal, _ := principalFromContext(r.Context())
+	userID := principal.UserID
+
+	dbChirp, err := cfg.DB.UnlikeChirp(chirpID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't unlike chirp")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, chirpFromDB(dbChirp))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tcluri/chirpy/internal/database"
+)
+
+type Report struct {
+	ID         int        `json:"id"`
+	ChirpID    int        `json:"chirp_id"`
+	ReporterID int        `json:"reporter_id"`
+	Reason     string     `json:"reason"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+func reportFromDB(report database.Report) Report {
+	return Report{
+		ID:         report.ID,
+		ChirpID:    report.ChirpID,
+		ReporterID: report.ReporterID,
+		Reason:     report.Reason,
+		CreatedAt:  report.CreatedAt,
+		ResolvedAt: report.ResolvedAt,
+	}
+}
+
+func (cfg *apiConfig) handlerChirpsReport(w http.ResponseWriter, r *http.Request) {
+	const maxReasonLength = 500
+
+	type parameters struct {
+		Reason string `json:"reason"`
+	}
+
+	chirpIDString := chi.URLParam(r, "chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	principal, _ := principalFromContext(r.Context())
+	userID := principal.UserID
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+	if params.Reason == "" {
+		respondWithError(w, http.StatusBadRequest, "Report needs a reason")
+		return
+	}
+	if len(params.Reason) > maxReasonLength {
+		respondWithError(w, http.StatusBadRequest, "Reason is too long")
+		return
+	}
+
+	report, err := cfg.DB.CreateReport(chirpID, userID, params.Reason)
+	if errors.Is(err, database.ErrAlreadyReported) {
+		respondWithError(w, http.StatusConflict, "Chirp already reported")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't report chirp")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, reportFromDB(report))
+}
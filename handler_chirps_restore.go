@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tcluri/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handlerChirpsRestore(w http.ResponseWriter, r *http.Request) {
+	chirpIDString := chi.URLParam(r, "chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	principal, _ := principalFromContext(r.Context())
+	userID := principal.UserID
+
+	deletedAfter := time.Now().UTC().Add(-cfg.chirpRetention)
+	dbChirp, err := cfg.DB.RestoreChirp(chirpID, userID, deletedAfter)
+	if err != nil {
+		if errors.Is(err, database.ErrRestoreExpired) {
+			respondWithError(w, http.StatusGone, "Chirp can no longer be restored")
+			return
+		}
+		if errors.Is(err, database.ErrRechirpOriginal) {
+			respondWithError(w, http.StatusConflict, "The rechirped chirp has been deleted")
+			return
+		}
+		if errors.Is(err, database.ErrAlreadyRechirped) {
+			respondWithError(w, http.StatusConflict, "Chirp already rechirped")
+			return
+		}
+		respondWithError(w, http.StatusForbidden, "Couldn't restore chirp")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, chirpFromDB(dbChirp))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/tcluri/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handlerChirpsSearch(w http.ResponseWriter, r *http.Request) {
+	const defaultSearchLimit = 20
+
+	query := database.SearchQuery{
+		Text:  r.URL.Query().Get("q"),
+		Limit: defaultSearchLimit,
+	}
+	if query.Text == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing search query")
+		return
+	}
+	switch r.URL.Query().Get("sort") {
+	case "", "relevance":
+	case "recent":
+		query.ByRecency = true
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid sort order")
+		return
+	}
+	limitString := r.URL.Query().Get("limit")
+	if limitString != "" {
+		limit, err := strconv.Atoi(limitString)
+		if err != nil || limit < 1 {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		if limit > maxChirpsLimit {
+			limit = maxChirpsLimit
+		}
+		query.Limit = limit
+	}
+
+	dbChirps, err := cfg.DB.SearchChirps(query)
+	if errors.Is(err, database.ErrInvalidSearch) {
+		respondWithError(w, http.StatusBadRequest, "Search query has no words to look for")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search chirps")
+		return
+	}
+
+	chirps := []Chirp{}
+	for _, dbChirp := range dbChirps {
+		chirps = append(chirps, chirpFromDB(dbChirp))
+	}
+	respondWithJSON(w, http.StatusOK, chirps)
+}
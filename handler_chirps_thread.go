@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tcluri/chirpy/internal/database"
+)
+
+const (
+	defaultThreadDepth = 10
+	maxThreadDepth     = 50
+)
+
+type ChirpThread struct {
+	Chirp
+	Replies []ChirpThread `json:"replies"`
+}
+
+func threadFromDB(dbThread database.ChirpThread) ChirpThread {
+	thread := ChirpThread{
+		Chirp:   chirpFromDB(dbThread.Chirp),
+		Replies: []ChirpThread{},
+	}
+	for _, reply := range dbThread.Replies {
+		thread.Replies = append(thread.Replies, threadFromDB(reply))
+	}
+	return thread
+}
+
+func (cfg *apiConfig) handlerChirpsThread(w http.ResponseWriter, r *http.Request) {
+	chirpIDString := chi.URLParam(r, "chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	depthString := r.URL.Query().Get("depth")
+	depth := defaultThreadDepth
+	if depthString != "" {
+		depth, err = strconv.Atoi(depthString)
+		if err != nil || depth < 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid depth")
+			return
+		}
+		if depth > maxThreadDepth {
+			depth = maxThreadDepth
+		}
+	}
+
+	dbThread, err := cfg.DB.GetChirpThread(chirpID, depth)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, threadFromDB(dbThread))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HashtagCount is one entry of the trending hashtags.
+type HashtagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+func (cfg *apiConfig) handlerHashtagChirps(w http.ResponseWriter, r *http.Request) {
+	query, err := parseChirpQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.Hashtag = chi.URLParam(r, "tag")
+	query.Desc = true
+
+	page, err := cfg.DB.QueryChirps(query)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
+		return
+	}
+	respondWithChirpPage(w, r, page)
+}
+
+func (cfg *apiConfig) handlerHashtagsTrending(w http.ResponseWriter, r *http.Request) {
+	const (
+		defaultTrendingWindow = 24 * time.Hour
+		maxTrendingWindow     = 7 * 24 * time.Hour
+		defaultTrendingLimit  = 10
+		maxTrendingLimit      = 100
+	)
+
+	window := defaultTrendingWindow
+	windowString := r.URL.Query().Get("window")
+	if windowString != "" {
+		var err error
+		window, err = time.ParseDuration(windowString)
+		if err != nil || window <= 0 || window > maxTrendingWindow {
+			respondWithError(w, http.StatusBadRequest, "Invalid window")
+			return
+		}
+	}
+	limit := defaultTrendingLimit
+	limitString := r.URL.Query().Get("limit")
+	if limitString != "" {
+		var err error
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit < 1 {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		if limit > maxTrendingLimit {
+			limit = maxTrendingLimit
+		}
+	}
+
+	dbTrending, err := cfg.DB.TrendingHashtags(time.Now().Add(-window), limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve trending hashtags")
+		return
+	}
+
+	trending := []HashtagCount{}
+	for _, count := range dbTrending {
+		trending = append(trending, HashtagCount{
+			Tag:   count.Tag,
+			Count: count.Count,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, trending)
+}
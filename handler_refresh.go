@@ -1,48 +1,124 @@
 package main
 
 import (
+	"errors"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/tcluri/chirpy/internal/auth"
+	"github.com/tcluri/chirpy/internal/database"
 )
 
+// refreshExpiry is how long a session lasts without being refreshed.
+const refreshExpiry = 60 * 24 * time.Hour
+
+// accessExpiry is how long an access token lasts.
+const accessExpiry = time.Hour
+
+func (cfg *apiConfig) createAccessToken(userID int, role string, tokenVersion int) (string, error) {
+	return auth.CreateJWT(userID, role, tokenVersion, cfg.jwtKeys, accessExpiry, "chirpy-access")
+}
+
+// createRefreshToken issues a refresh token for a user, along with the
+// session record for it, which only holds the token's hash.
+func (cfg *apiConfig) createRefreshToken(userID int, tokenVersion int, r *http.Request) (string, database.Session, error) {
+	token, err := auth.CreateJWT(userID, "", tokenVersion, cfg.jwtKeys, refreshExpiry, "chirpy-refresh")
+	if err != nil {
+		return "", database.Session{}, err
+	}
+	session := database.Session{
+		UserID:    userID,
+		TokenHash: auth.HashToken(token),
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+		ExpiresAt: time.Now().UTC().Add(refreshExpiry),
+	}
+	return token, session, nil
+}
+
+// clientIP returns the address a request came from, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		respondUnauthorized(w, errNoCredentials)
+		return
+	}
+	claims, account, err := auth.ValidateRefreshJWT(refreshToken, cfg.jwtKeys, cfg.lookupAccount)
+	if errors.Is(err, errUserSuspended) {
+		respondAuthError(w, err)
 		return
 	}
-	isRevoked, err := cfg.DB.IsTokenRevoked(refreshToken)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't check session")
+		respondUnauthorized(w, errors.New("Couldn't validate JWT"))
 		return
 	}
-	if isRevoked {
-		respondWithError(w, http.StatusUnauthorized, "Refresh token is revoked")
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		respondUnauthorized(w, errors.New("Couldn't validate JWT"))
+		return
+	}
+
+	// Both tokens are made before the session is rotated, so that nothing
+	// can fail between the old refresh token being revoked and the client
+	// receiving the new one
+	accessToken, err := cfg.createAccessToken(userID, account.Role, account.TokenVersion)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT")
+		return
+	}
+	// Every refresh replaces the refresh token
+	newRefreshToken, next, err := cfg.createRefreshToken(userID, account.TokenVersion, r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create JWT for refresh")
+		return
+	}
+	_, err = cfg.DB.RotateSession(auth.HashToken(refreshToken), next)
+	if errors.Is(err, database.ErrTokenReused) {
+		respondUnauthorized(w, errors.New("Refresh token was already used; the session has been revoked"))
+		return
+	}
+	if errors.Is(err, database.ErrSessionNotFound) {
+		respondUnauthorized(w, errors.New("Refresh token is revoked"))
 		return
 	}
-	new_access_token, err := auth.RefreshToken(refreshToken, cfg.jwtSecret)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check session")
 		return
 	}
+
 	respondWithJSON(w, http.StatusOK, response{
-		Token: new_access_token,
+		Token:        accessToken,
+		RefreshToken: newRefreshToken,
 	})
 }
 
 func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't find JWT")
+		respondUnauthorized(w, errNoCredentials)
 		return
 	}
 
-	err = cfg.DB.RevokeToken(refreshToken)
+	err = cfg.DB.RevokeSession(auth.HashToken(refreshToken))
+	if errors.Is(err, database.ErrSessionNotFound) {
+		respondUnauthorized(w, errors.New("Refresh token is revoked"))
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session")
 		return
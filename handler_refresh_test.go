@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRefreshAndRevokeChallenge(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, srv *httptest.Server) {
+		tests := []struct {
+			name   string
+			path   string
+			token  string
+			header string
+		}{
+			{"refresh without token", "/api/refresh", "", `Bearer realm="chirpy"`},
+			{"refresh with invalid token", "/api/refresh", "not a token", `Bearer realm="chirpy", error="invalid_token"`},
+			{"revoke without token", "/api/revoke", "", `Bearer realm="chirpy"`},
+		}
+		for _, tt := range tests {
+			req, err := http.NewRequest("POST", srv.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("%s: got status %d, want 401", tt.name, resp.StatusCode)
+			}
+			if got := resp.Header.Get("WWW-Authenticate"); got != tt.header {
+				t.Errorf("%s: got WWW-Authenticate %q, want %q", tt.name, got, tt.header)
+			}
+		}
+	})
+}
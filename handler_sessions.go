@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tcluri/chirpy/internal/database"
+)
+
+// Session is a device a user is logged in on.
+type Session struct {
+	ID         int       `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+func sessionFromDB(session database.Session) Session {
+	return Session{
+		ID:         session.ID,
+		UserAgent:  session.UserAgent,
+		IP:         session.IP,
+		CreatedAt:  session.CreatedAt,
+		LastSeenAt: session.LastUsedAt,
+		ExpiresAt:  session.ExpiresAt,
+	}
+}
+
+func (cfg *apiConfig) handlerSessionsList(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+
+	dbSessions, err := cfg.DB.GetSessions(principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve sessions")
+		return
+	}
+
+	sessions := []Session{}
+	for _, dbSession := range dbSessions {
+		sessions = append(sessions, sessionFromDB(dbSession))
+	}
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+func (cfg *apiConfig) handlerSessionsDelete(w http.ResponseWriter, r *http.Request) {
+	sessionIDString := chi.URLParam(r, "sessionID")
+	sessionID, err := strconv.Atoi(sessionIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+	principal, _ := principalFromContext(r.Context())
+
+	err = cfg.DB.DeleteSession(principal.UserID, sessionID)
+	if errors.Is(err, database.ErrSessionNotFound) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find session")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete session")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, struct{}{})
+}
+
+// handlerSessionsDeleteAll logs the user out everywhere.
+func (cfg *apiConfig) handlerSessionsDeleteAll(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+
+	err := cfg.DB.DeleteSessions(principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete sessions")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, struct{}{})
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+)
+
+func (cfg *apiConfig) handlerTimeline(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	userID := principal.UserID
+
+	query, err := parseChirpQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.FollowedBy = userID
+	query.Desc = true
+
+	page, err := cfg.DB.QueryChirps(query)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve timeline")
+		return
+	}
+	respondWithChirpPage(w, r, page)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/tcluri/chirpy/internal/auth"
 	"github.com/tcluri/chirpy/internal/database"
@@ -14,6 +15,27 @@ type User struct {
 	Email       string `json:"email"`
 	Password    string `json:"-"`
 	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Role        string `json:"role"`
+	Handle      string `json:"handle,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Bio         string `json:"bio,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	// SuspendedAt is set while the user is suspended
+	SuspendedAt *time.Time `json:"suspended_at,omitempty"`
+}
+
+func userFromDB(user database.User) User {
+	return User{
+		ID:          user.ID,
+		Email:       user.Email,
+		IsChirpyRed: user.IsChirpyRed,
+		Role:        user.Role,
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   user.AvatarURL,
+		SuspendedAt: user.SuspendedAt,
+	}
 }
 
 func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request) {
@@ -50,10 +72,6 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	respondWithJSON(w, http.StatusCreated, response{
-		User: User{
-			ID:          user.ID,
-			Email:       user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		},
+		User: userFromDB(user),
 	})
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tcluri/chirpy/internal/database"
+)
+
+// Follow is one entry of a follower or following list: the other user and
+// when the follow was made.
+type Follow struct {
+	UserID     int       `json:"user_id"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+func (cfg *apiConfig) handlerUsersFollow(w http.ResponseWriter, r *http.Request) {
+	followeeIDString := chi.URLParam(r, "userID")
+	followeeID, err := strconv.Atoi(followeeIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	principal, _ := principalFromContext(r.Context())
+	userID := principal.UserID
+
+	err = cfg.DB.FollowUser(userID, followeeID)
+	if errors.Is(err, database.ErrFollowSelf) {
+		respondWithError(w, http.StatusBadRequest, "Users cannot follow themselves")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't follow user")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, struct{}{})
+}
+
+func (cfg *apiConfig) handlerUsersUnfollow(w http.ResponseWriter, r *http.Request) {
+	followeeIDString := chi.URLParam(r, "userID")
+	followeeID, err := strconv.Atoi(followeeIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	principal, _ := principalFromContext(r.Context())
+	userID := principal.UserID
+
+	err = cfg.DB.UnfollowUser(userID, followeeID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't unfollow user")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, struct{}{})
+}
+
+func (cfg *apiConfig) handlerUsersFollowers(w http.ResponseWriter, r *http.Request) {
+	userIDString := chi.URLParam(r, "userID")
+	userID, err := strconv.Atoi(userIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	dbFollows, err := cfg.DB.GetFollowers(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user")
+		return
+	}
+
+	follows := []Follow{}
+	for _, dbFollow := range dbFollows {
+		follows = append(follows, Follow{
+			UserID:     dbFollow.FollowerID,
+			FollowedAt: dbFollow.CreatedAt,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, follows)
+}
+
+func (cfg *apiConfig) handlerUsersFollowing(w http.ResponseWriter, r *http.Request) {
+	userIDString := chi.URLParam(r, "userID")
+	userID, err := strconv.Atoi(userIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	dbFollows, err := cfg.DB.GetFollowing(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user")
+		return
+	}
+
+	follows := []Follow{}
+	for _, dbFollow := range dbFollows {
+		follows = append(follows, Follow{
+			UserID:     dbFollow.FolloweeID,
+			FollowedAt: dbFollow.CreatedAt,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, follows)
+}
@@ -3,9 +3,9 @@ package main
 import (
 	"encoding/json"
 	"net/http"
-	"time"
 
 	"github.com/tcluri/chirpy/internal/auth"
+	"github.com/tcluri/chirpy/internal/database"
 )
 
 func (cfg *apiConfig) handlerUsersLogin(w http.ResponseWriter, r *http.Request) {
@@ -37,32 +37,41 @@ func (cfg *apiConfig) handlerUsersLogin(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusUnauthorized, "Invalid password")
 		return
 	}
-
-	// Access token chirpy-access
-	access_issuer := "chirpy-access"
-	access_expiry := 60 * 60
-	access_token, err := auth.CreateJWT(user.ID, cfg.jwtSecret, time.Duration(access_expiry)*time.Second, access_issuer)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create JWT for access")
+	if user.SuspendedAt != nil {
+		respondWithError(w, http.StatusForbidden, "User is suspended")
 		return
 	}
 
-	// Refresh token chirpy-refresh
-	refresh_issuer := "chirpy-refresh"
-	refresh_expiry := 60 * 60 * 24 * 60
-	refresh_token, err := auth.CreateJWT(user.ID, cfg.jwtSecret, time.Duration(refresh_expiry)*time.Second, refresh_issuer)
+	access_token, refresh_token, err := cfg.startSession(user, r)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create JWT for refresh")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session")
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		User: User{
-			ID:          user.ID,
-			Email:       user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		},
+		User:         userFromDB(user),
 		Token:        access_token,
 		RefreshToken: refresh_token,
 	})
 }
+
+// startSession logs a user in on the device making the request: it issues
+// an access token and a refresh token, and stores the session the refresh
+// token belongs to.
+func (cfg *apiConfig) startSession(user database.User, r *http.Request) (string, string, error) {
+	access_token, err := cfg.createAccessToken(user.ID, user.Role, user.TokenVersion)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Refresh token chirpy-refresh, starting a session for this device
+	refresh_token, session, err := cfg.createRefreshToken(user.ID, user.TokenVersion, r)
+	if err != nil {
+		return "", "", err
+	}
+	_, err = cfg.DB.CreateSession(session)
+	if err != nil {
+		return "", "", err
+	}
+	return access_token, refresh_token, nil
+}
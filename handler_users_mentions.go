@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (cfg *apiConfig) handlerUsersMentions(w http.ResponseWriter, r *http.Request) {
+	userIDString := chi.URLParam(r, "userID")
+	userID, err := strconv.Atoi(userIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	query, err := parseChirpQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.Mentioning = userID
+	query.Desc = true
+
+	if _, err := cfg.DB.GetUser(userID); err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user")
+		return
+	}
+	page, err := cfg.DB.QueryChirps(query)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve mentions")
+		return
+	}
+	respondWithChirpPage(w, r, page)
+}
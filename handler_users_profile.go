@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tcluri/chirpy/internal/database"
+)
+
+// Profile is the public view of a user. Unlike User it only includes the
+// email address when users look at their own profile.
+type Profile struct {
+	ID          int       `json:"id"`
+	Email       string    `json:"email,omitempty"`
+	Handle      string    `json:"handle,omitempty"`
+	DisplayName string    `json:"display_name,omitempty"`
+	Bio         string    `json:"bio,omitempty"`
+	AvatarURL   string    `json:"avatar_url,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func profileFromDB(user database.User) Profile {
+	return Profile{
+		ID:          user.ID,
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   user.AvatarURL,
+		CreatedAt:   user.CreatedAt,
+	}
+}
+
+// profileFor returns the profile of user as the request's principal gets
+// to see it.
+func profileFor(r *http.Request, user database.User) Profile {
+	profile := profileFromDB(user)
+	if principal, ok := principalFromContext(r.Context()); ok && principal.UserID == user.ID {
+		profile.Email = user.Email
+	}
+	return profile
+}
+
+var handlePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,15}$`)
+
+func (cfg *apiConfig) handlerUsersGet(w http.ResponseWriter, r *http.Request) {
+	userIDString := chi.URLParam(r, "userID")
+	userID, err := strconv.Atoi(userIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, profileFor(r, user))
+}
+
+func (cfg *apiConfig) handlerUsersGetByHandle(w http.ResponseWriter, r *http.Request) {
+	handle := chi.URLParam(r, "handle")
+
+	user, err := cfg.DB.GetUserByHandle(handle)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, profileFor(r, user))
+}
+
+func (cfg *apiConfig) handlerUsersUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Handle      string `json:"handle"`
+		DisplayName string `json:"display_name"`
+		Bio         string `json:"bio"`
+		AvatarURL   string `json:"avatar_url"`
+	}
+
+	principal, _ := principalFromContext(r.Context())
+	userID := principal.UserID
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+
+	profile := database.Profile{
+		Handle:      params.Handle,
+		DisplayName: params.DisplayName,
+		Bio:         params.Bio,
+		AvatarURL:   params.AvatarURL,
+	}
+	err = validateProfile(profile)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := cfg.DB.UpdateProfile(userID, profile)
+	if errors.Is(err, database.ErrHandleTaken) {
+		respondWithError(w, http.StatusConflict, "Handle already taken")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update profile")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, userFromDB(user))
+}
+
+func validateProfile(profile database.Profile) error {
+	const (
+		maxDisplayNameLength = 50
+		maxBioLength         = 160
+		maxAvatarURLLength   = 2048
+	)
+	// An empty handle leaves the user without one
+	if profile.Handle != "" && !handlePattern.MatchString(profile.Handle) {
+		return errors.New("Handle must be 3 to 15 letters, digits or underscores")
+	}
+	if utf8.RuneCountInString(profile.DisplayName) > maxDisplayNameLength {
+		return errors.New("Display name is too long")
+	}
+	if utf8.RuneCountInString(profile.Bio) > maxBioLength {
+		return errors.New("Bio is too long")
+	}
+	if profile.AvatarURL != "" {
+		if len(profile.AvatarURL) > maxAvatarURLLength {
+			return errors.New("Avatar URL is too long")
+		}
+		u, err := url.Parse(profile.AvatarURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("Avatar URL must be an http or https URL")
+		}
+	}
+	return nil
+}
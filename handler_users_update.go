@@ -2,10 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
-	"strconv"
 
 	"github.com/tcluri/chirpy/internal/auth"
+	"github.com/tcluri/chirpy/internal/database"
 )
 
 func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request) {
@@ -15,22 +16,15 @@ func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request)
 	}
 	type response struct {
 		User
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
-		return
-	}
-	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
-		return
-	}
+	principal, _ := principalFromContext(r.Context())
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
 		return
@@ -42,23 +36,27 @@ func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	userIDInt, err := strconv.Atoi(subject)
+	// The new credentials log out every device, this one included, so it
+	// gets a fresh session
+	user, err := cfg.DB.UpdateUser(principal.UserID, params.Email, hashedPassword)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't parse user ID")
+		if errors.Is(err, database.ErrAlreadyExists) {
+			respondWithError(w, http.StatusConflict, "User already exists")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user")
 		return
 	}
-
-	user, err := cfg.DB.UpdateUser(userIDInt, params.Email, hashedPassword)
+	accessToken, refreshToken, err := cfg.startSession(user, r)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create user")
+		// The update stands; logging in with the new credentials still works
+		respondWithError(w, http.StatusInternalServerError, "Updated user, but couldn't create session; log in again")
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		User: User{
-			ID:          user.ID,
-			Email:       user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		},
+		User:         userFromDB(user),
+		Token:        accessToken,
+		RefreshToken: refreshToken,
 	})
 }
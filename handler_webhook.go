@@ -15,7 +15,7 @@ func (cfg *apiConfig) handlerUserUpgrade(w http.ResponseWriter, r *http.Request)
 		} `json:"data"`
 	}
 
-	apiKey, err := auth.GetPolkaApiKey(r.Header)
+	apiKey, err := auth.GetAPIKey(r.Header)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
 		return
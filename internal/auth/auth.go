@@ -1,6 +1,9 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"net/http"
 	"strconv"
@@ -30,15 +33,47 @@ func CheckPasswordHash(password string, hashedPass []byte) error {
 	return nil
 }
 
-func CreateJWT(userid int, jwtSecret string, expirytime time.Duration, issuer string) (string, error) {
-	registeredClaims := jwt.RegisteredClaims{
-		Issuer:    issuer,
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expirytime)),
-		Subject:   strconv.Itoa(userid),
+// Claims are the claims of the tokens chirpy issues. Role is the user's
+// role when the token was issued; refresh tokens don't carry one, so that
+// every access token gets the role current at the time it is issued.
+// TokenVersion is the user's token version at the time.
+type Claims struct {
+	Role         string `json:"role,omitempty"`
+	TokenVersion int    `json:"ver"`
+	jwt.RegisteredClaims
+}
+
+// Account is what checking a token needs to know about its user as they are
+// now.
+type Account struct {
+	Role         string
+	TokenVersion int
+}
+
+// AccountLookup returns the current Account of a user.
+type AccountLookup func(userID int) (Account, error)
+
+var ErrTokenVersion = errors.New("Token was issued before the user's credentials changed")
+
+func CreateJWT(userid int, role string, tokenVersion int, keys *KeySet, expirytime time.Duration, issuer string) (string, error) {
+	// A random ID keeps two tokens issued in the same second apart
+	tokenID := make([]byte, 16)
+	_, err := rand.Read(tokenID)
+	if err != nil {
+		return "", err
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, registeredClaims)
-	signedToken, err := token.SignedString([]byte(jwtSecret))
+	claims := Claims{
+		Role:         role,
+		TokenVersion: tokenVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(tokenID),
+			Issuer:    issuer,
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expirytime)),
+			Subject:   strconv.Itoa(userid),
+		},
+	}
+	signedToken, err := keys.sign(claims)
 	if err != nil {
 		return "", err
 	}
@@ -54,7 +89,7 @@ func GetBearerToken(header http.Header) (string, error) {
 	return token, nil
 }
 
-func GetPolkaApiKey(header http.Header) (string, error) {
+func GetAPIKey(header http.Header) (string, error) {
 	authField := header.Get("Authorization")
 	apiKey := strings.TrimPrefix(authField, "ApiKey ")
 	if apiKey == "" {
@@ -63,50 +98,82 @@ func GetPolkaApiKey(header http.Header) (string, error) {
 	return apiKey, nil
 }
 
-func ValidateJWT(tokenString string, jwtSecret string) (string, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtSecret), nil
-	})
+func ValidateJWT(tokenString string, keys *KeySet, lookup AccountLookup) (string, error) {
+	claims, _, err := ValidateJWTClaims(tokenString, keys, lookup)
 	if err != nil {
 		return "", err
 	}
-	if claims, ok := token.Claims.(*jwt.RegisteredClaims); ok && token.Valid {
+	return claims.Subject, nil
+}
+
+// ValidateJWTClaims is ValidateJWT returning all of the access token's
+// claims rather than only the subject, along with the user's Account as it
+// is now. Authorization should go by the Account, whose role may have
+// changed since the token was issued.
+func ValidateJWTClaims(tokenString string, keys *KeySet, lookup AccountLookup) (Claims, Account, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, keys.keyFunc)
+	if err != nil {
+		return Claims{}, Account{}, err
+	}
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
 		if claims.Issuer == "chirpy-refresh" {
-			return "", errors.New("Token issuer is a refresh token")
+			return Claims{}, Account{}, errors.New("Token issuer is a refresh token")
 		}
 		if claims.ExpiresAt != nil && claims.ExpiresAt.Before(time.Now().UTC()) {
-			return "", errors.New("Token expired")
+			return Claims{}, Account{}, errors.New("Token expired")
+		}
+		account, err := checkVersion(*claims, lookup)
+		if err != nil {
+			return Claims{}, Account{}, err
 		}
-		userID := claims.Subject
-		return userID, nil
+		return *claims, account, nil
 	} else {
-		return "", errors.New("Couldn't validate JWT token")
+		return Claims{}, Account{}, errors.New("Couldn't validate JWT token")
 	}
 }
 
-func RefreshToken(tokenString string, jwtSecret string) (string, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtSecret), nil
-	})
+// ValidateRefreshJWT checks a refresh token's signature, expiry, issuer and
+// version and returns its claims, along with the user's Account as it is
+// now.
+func ValidateRefreshJWT(tokenString string, keys *KeySet, lookup AccountLookup) (Claims, Account, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, keys.keyFunc)
 	if err != nil {
-		return "", err
+		return Claims{}, Account{}, err
 	}
-	if claims, ok := token.Claims.(*jwt.RegisteredClaims); ok && token.Valid {
-		if claims.Issuer == "chirpy-refresh" {
-			userID, err := strconv.Atoi(claims.Subject)
-			if err != nil {
-				return "", err
-			}
-			expiryTime := time.Duration(time.Hour)
-			accessIssuer := "chirpy-access"
-			access_token, err := CreateJWT(userID, jwtSecret, expiryTime, accessIssuer)
-			if err != nil {
-				return "", err
-			}
-			return access_token, nil
-		} else {
-			return "", errors.New("Couldn't refresh access token: claims issuer is not refresh token")
-		}
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return Claims{}, Account{}, errors.New("Couldn't refresh access token: token invalid")
+	}
+	if claims.Issuer != "chirpy-refresh" {
+		return Claims{}, Account{}, errors.New("Couldn't refresh access token: claims issuer is not refresh token")
+	}
+	account, err := checkVersion(*claims, lookup)
+	if err != nil {
+		return Claims{}, Account{}, err
+	}
+	return *claims, account, nil
+}
+
+// checkVersion looks up the token's user and makes sure the token was
+// issued for their current token version.
+func checkVersion(claims Claims, lookup AccountLookup) (Account, error) {
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return Account{}, err
+	}
+	account, err := lookup(userID)
+	if err != nil {
+		return Account{}, err
 	}
-	return "", errors.New("Couldn't refresh access token: token invalid")
+	if claims.TokenVersion != account.TokenVersion {
+		return Account{}, ErrTokenVersion
+	}
+	return account, nil
+}
+
+// HashToken returns the hex SHA-256 of a token, the form refresh tokens are
+// stored in.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
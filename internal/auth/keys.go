@@ -0,0 +1,423 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// The algorithms KeySet can generate keys for.
+const (
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmRS256 = "RS256"
+)
+
+// KeyConfig configures a KeySet.
+type KeyConfig struct {
+	// Dir holds the keys, one PEM file per key, named after its key ID:
+	// kid.pem. Without it tokens are signed with Secret.
+	Dir string
+	// Algorithm is the kind of key to generate, AlgorithmEdDSA or
+	// AlgorithmRS256
+	Algorithm string
+	// Rotation is how old the signing key gets before a new one replaces
+	// it; zero never replaces it
+	Rotation time.Duration
+	// Retention is how long a replaced key still verifies tokens, which
+	// should be the lifetime of the longest-lived token
+	Retention time.Duration
+	// Secret is an HS256 secret. It signs when there is no Dir, and
+	// otherwise still verifies the tokens it signed earlier.
+	Secret string
+}
+
+// KeySet signs tokens with the newest private key in its directory and
+// verifies them with whichever key the token's kid header names. It is safe
+// for concurrent use.
+type KeySet struct {
+	cfg        KeyConfig
+	mux        sync.RWMutex
+	keys       map[string]*key
+	signing    *key
+	lastReload time.Time
+}
+
+type key struct {
+	id     string
+	path   string
+	method jwt.SigningMethod
+	signer crypto.Signer
+	public crypto.PublicKey
+	// createdAt is read from the key ID of generated keys, and is zero for
+	// keys added by hand
+	createdAt time.Time
+}
+
+// keyTimeLayout is the start of the name of every generated key file. The
+// time in the name, rather than the file's modification time, says how old
+// the key is, so that copying the directory or restoring it from a backup
+// doesn't change when keys are rotated and deleted.
+const keyTimeLayout = "20060102T150405Z"
+
+// generated reports whether the key was made by generateKey.
+func (k *key) generated() bool {
+	return !k.createdAt.IsZero()
+}
+
+// NewKeySet loads the keys in cfg.Dir, generating a first one if there is
+// no private key to sign with.
+func NewKeySet(cfg KeyConfig) (*KeySet, error) {
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = AlgorithmEdDSA
+	}
+	if cfg.Algorithm != AlgorithmEdDSA && cfg.Algorithm != AlgorithmRS256 {
+		return nil, fmt.Errorf("unsupported key algorithm %q", cfg.Algorithm)
+	}
+	if cfg.Dir == "" && cfg.Secret == "" {
+		return nil, errors.New("either a key directory or a secret is needed")
+	}
+	ks := &KeySet{cfg: cfg, keys: map[string]*key{}}
+	if cfg.Dir == "" {
+		return ks, nil
+	}
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := ks.Rotate(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Reload reads the key directory again, picking up keys added or removed
+// by hand or by another server sharing it. On error the old keys stay in
+// place.
+func (ks *KeySet) Reload() error {
+	if ks.cfg.Dir == "" {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(ks.cfg.Dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	keys := map[string]*key{}
+	var signing *key
+	for _, path := range paths {
+		k, err := loadKey(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		keys[k.id] = k
+		if k.signer != nil && (signing == nil || newer(k, signing)) {
+			signing = k
+		}
+	}
+
+	ks.mux.Lock()
+	ks.keys = keys
+	ks.signing = signing
+	ks.lastReload = time.Now()
+	ks.mux.Unlock()
+	return nil
+}
+
+// Rotate reloads the keys and generates a new signing key if there is none
+// or the current one is older than the rotation period. Keys added by hand
+// count as older than any generated key. With rotation on, it also deletes
+// the generated private keys that were replaced longer ago than the
+// retention period, since every token they signed has expired; keys added
+// by hand are left for whoever added them to remove.
+func (ks *KeySet) Rotate() error {
+	if ks.cfg.Dir == "" {
+		return nil
+	}
+	if err := ks.Reload(); err != nil {
+		return err
+	}
+	now := time.Now()
+
+	ks.mux.RLock()
+	signing := ks.signing
+	ks.mux.RUnlock()
+	if signing == nil || (ks.cfg.Rotation > 0 && now.Sub(signing.createdAt) >= ks.cfg.Rotation) {
+		if err := generateKey(ks.cfg.Dir, ks.cfg.Algorithm, now); err != nil {
+			return err
+		}
+		if err := ks.Reload(); err != nil {
+			return err
+		}
+	}
+	if ks.cfg.Rotation <= 0 {
+		return nil
+	}
+
+	ks.mux.RLock()
+	private := []*key{}
+	for _, k := range ks.keys {
+		if k.signer != nil && k.generated() {
+			private = append(private, k)
+		}
+	}
+	ks.mux.RUnlock()
+	sort.Slice(private, func(i, j int) bool { return newer(private[j], private[i]) })
+	removed := false
+	for i := 0; i+1 < len(private); i++ {
+		// A key stopped signing when the next one was created
+		replacedAt := private[i+1].createdAt
+		if now.Sub(replacedAt) < ks.cfg.Retention {
+			continue
+		}
+		if err := os.Remove(private[i].path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		removed = true
+	}
+	if removed {
+		return ks.Reload()
+	}
+	return nil
+}
+
+func newer(a, b *key) bool {
+	if !a.createdAt.Equal(b.createdAt) {
+		return a.createdAt.After(b.createdAt)
+	}
+	return a.id > b.id
+}
+
+// sign signs claims with the signing key, or the secret when there are no
+// keys.
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	ks.mux.RLock()
+	signing := ks.signing
+	ks.mux.RUnlock()
+	if signing == nil {
+		if ks.cfg.Secret == "" {
+			return "", errors.New("No key to sign with")
+		}
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(ks.cfg.Secret))
+	}
+	token := jwt.NewWithClaims(signing.method, claims)
+	token.Header["kid"] = signing.id
+	return token.SignedString(signing.signer)
+}
+
+// keyFunc finds the key to verify a token with. Each key only verifies the
+// algorithm it is for, so that a token can't pass off, say, an HMAC made
+// with a public key as valid.
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		if ks.cfg.Secret == "" || token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("Token has no key ID")
+		}
+		return []byte(ks.cfg.Secret), nil
+	}
+	k, ok := ks.key(kid)
+	if !ok && ks.reloadForUnknownKey() {
+		// Another server sharing the directory may have just rotated
+		k, ok = ks.key(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("Unknown key ID %q", kid)
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("Key %q is not for %s", kid, token.Method.Alg())
+	}
+	return k.public, nil
+}
+
+func (ks *KeySet) key(kid string) (*key, bool) {
+	ks.mux.RLock()
+	defer ks.mux.RUnlock()
+	k, ok := ks.keys[kid]
+	return k, ok
+}
+
+// reloadForUnknownKey reloads the keys when a token names a key ID that
+// isn't loaded, at most once a minute so that made-up key IDs can't keep
+// the server reading the directory.
+func (ks *KeySet) reloadForUnknownKey() bool {
+	const minInterval = time.Minute
+	ks.mux.Lock()
+	if ks.cfg.Dir == "" || time.Since(ks.lastReload) < minInterval {
+		ks.mux.Unlock()
+		return false
+	}
+	ks.lastReload = time.Now()
+	ks.mux.Unlock()
+	return ks.Reload() == nil
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517).
+type JWK struct {
+	KeyType string `json:"kty"`
+	Use     string `json:"use"`
+	Alg     string `json:"alg"`
+	KeyID   string `json:"kid"`
+	// N and E are set for RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Curve and X are set for Ed25519 keys
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every key, sorted by key ID. The HS256
+// secret is never included.
+func (ks *KeySet) JWKS() JWKS {
+	ks.mux.RLock()
+	defer ks.mux.RUnlock()
+
+	set := JWKS{Keys: []JWK{}}
+	for _, k := range ks.keys {
+		jwk := JWK{Use: "sig", Alg: k.method.Alg(), KeyID: k.id}
+		switch public := k.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
+
+// loadKey reads a PEM file holding an RSA or Ed25519 private key, in PKCS
+// #8 or, for RSA, PKCS #1 form, or a public key, which only verifies.
+func loadKey(path string) (*key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	id := strings.TrimSuffix(filepath.Base(path), ".pem")
+	k := &key{id: id, path: path}
+	if len(id) >= len(keyTimeLayout) {
+		createdAt, err := time.Parse(keyTimeLayout, id[:len(keyTimeLayout)])
+		if err == nil {
+			k.createdAt = createdAt
+		}
+	}
+	switch parsed := parsed.(type) {
+	case *rsa.PrivateKey:
+		k.method, k.signer, k.public = jwt.SigningMethodRS256, parsed, &parsed.PublicKey
+	case *rsa.PublicKey:
+		k.method, k.public = jwt.SigningMethodRS256, parsed
+	case ed25519.PrivateKey:
+		k.method, k.signer, k.public = jwt.SigningMethodEdDSA, parsed, parsed.Public()
+	case ed25519.PublicKey:
+		k.method, k.public = jwt.SigningMethodEdDSA, parsed
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	return k, nil
+}
+
+// generateKey writes a new private key to dir, named after the time it was
+// made so that key IDs sort by age. Random bytes follow the time, so that
+// servers sharing the directory that rotate in the same second don't pick
+// the same name.
+func generateKey(dir string, algorithm string, now time.Time) error {
+	var private interface{}
+	var err error
+	switch algorithm {
+	case AlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := now.UTC().Format(keyTimeLayout) + "-" + hex.EncodeToString(suffix) + ".pem"
+	// Write the key under a temp name, which the *.pem glob skips, so that a
+	// crash never leaves a truncated key for the next load to choke on
+	tmp, err := os.CreateTemp(dir, name+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	// Clean up the temp file on any failure before the rename
+	defer os.Remove(tmpPath)
+
+	if err := pem.Encode(tmp, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes a preceding rename or remove in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
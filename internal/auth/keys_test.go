@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func lookupUser(userID int) (Account, error) {
+	return Account{Role: "user"}, nil
+}
+
+func keyIDs(t *testing.T, dir string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, path := range paths {
+		ids = append(ids, filepath.Base(path[:len(path)-len(".pem")]))
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// writeHandMadeKey adds an Ed25519 private key named id to dir, as an
+// operator would, and returns it.
+func writeHandMadeKey(t *testing.T, dir string, id string) ed25519.PrivateKey {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, id+".pem"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return private
+}
+
+func TestKeyAgeComesFromName(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	if err := generateKey(dir, AlgorithmEdDSA, now.Add(-48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	old := keyIDs(t, dir)[0]
+	// Copying the directory gives the old key a fresh modification time
+	if err := os.Chtimes(filepath.Join(dir, old+".pem"), now, now); err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := NewKeySet(KeyConfig{Dir: dir, Rotation: 24 * time.Hour, Retention: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := keyIDs(t, dir)
+	if len(ids) != 2 || ids[0] != old {
+		t.Fatalf("got keys %v, want %s and a new one", ids, old)
+	}
+	token, err := CreateJWT(1, "user", 0, ks, time.Hour, "chirpy-access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header["kid"] != ids[1] {
+		t.Errorf("token signed with %v, want the new key %s", parsed.Header["kid"], ids[1])
+	}
+}
+
+func TestGenerateKeyLeavesOnlyKey(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	if err := generateKey(dir, AlgorithmRS256, now); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || filepath.Ext(entries[0].Name()) != ".pem" {
+		t.Fatalf("got %v, want only the key", entries)
+	}
+	path := filepath.Join(dir, entries[0].Name())
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("got mode %v, want 0600", info.Mode().Perm())
+	}
+	if _, err := loadKey(path); err != nil {
+		t.Errorf("generated key doesn't load: %s", err)
+	}
+}
+
+func TestRotateDeletesExpiredKeys(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for _, age := range []time.Duration{100 * time.Hour, 50 * time.Hour} {
+		if err := generateKey(dir, AlgorithmEdDSA, now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := keyIDs(t, dir)
+	oldest, replaced := ids[0], ids[1]
+	writeHandMadeKey(t, dir, "hand-made")
+
+	_, err := NewKeySet(KeyConfig{Dir: dir, Rotation: 24 * time.Hour, Retention: 10 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The oldest key was replaced 50 hours ago, past retention; the one
+	// that replaced it was only replaced now. Hand-made keys stay.
+	ids = keyIDs(t, dir)
+	want := map[string]bool{replaced: true, "hand-made": true}
+	if len(ids) != 3 {
+		t.Fatalf("got keys %v, want %s, hand-made and a new one", ids, replaced)
+	}
+	for _, id := range ids {
+		if id == oldest {
+			t.Errorf("key %s outlived retention", oldest)
+		}
+		delete(want, id)
+	}
+	if len(want) != 0 {
+		t.Errorf("keys %v were deleted", want)
+	}
+}
+
+func TestHandMadeKeyVerifiesAndSigns(t *testing.T) {
+	dir := t.TempDir()
+	private := writeHandMadeKey(t, dir, "operator")
+
+	// Without rotation the only private key signs, whatever its name
+	ks, err := NewKeySet(KeyConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := keyIDs(t, dir); len(ids) != 1 {
+		t.Fatalf("got keys %v, want only the hand-made one", ids)
+	}
+	token, err := CreateJWT(1, "user", 0, ks, time.Hour, "chirpy-access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(token, ks, lookupUser); err != nil {
+		t.Fatal(err)
+	}
+
+	// With rotation a generated key takes over, and the hand-made one
+	// still verifies what it signed
+	handMade := jwt.NewWithClaims(jwt.SigningMethodEdDSA, Claims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:   "1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}})
+	handMade.Header["kid"] = "operator"
+	signed, err := handMade.SignedString(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err = NewKeySet(KeyConfig{Dir: dir, Rotation: time.Hour, Retention: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := keyIDs(t, dir); len(ids) != 2 {
+		t.Fatalf("got keys %v, want the hand-made one and a new one", ids)
+	}
+	if _, err := ValidateJWT(signed, ks, lookupUser); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServersRotatingTogether(t *testing.T) {
+	dir := t.TempDir()
+	const servers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, servers)
+	for i := 0; i < servers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := NewKeySet(KeyConfig{Dir: dir})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Every server may have made a key; a server that loaded the
+	// directory afterwards signs with the newest of them
+	ks, err := NewKeySet(KeyConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := CreateJWT(1, "user", 0, ks, time.Hour, "chirpy-access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(token, ks, lookupUser); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyFuncRejectsAlgorithmMismatch(t *testing.T) {
+	ks, err := NewKeySet(KeyConfig{Dir: t.TempDir(), Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid := ks.JWKS().Keys[0].KeyID
+
+	// An HMAC naming an Ed25519 key must not be checked against anything
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "1"}})
+	forged.Header["kid"] = kid
+	token, err := forged.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(token, ks, lookupUser); err == nil {
+		t.Error("HS256 token naming an EdDSA key was accepted")
+	}
+
+	// Tokens signed with the secret before there were keys still verify
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "1"}}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(legacy, ks, lookupUser); err != nil {
+		t.Errorf("HS256 token without a key ID was refused: %s", err)
+	}
+}
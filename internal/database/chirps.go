@@ -3,26 +3,43 @@ package database
 import (
 	"errors"
 	"sort"
+	"time"
 )
 
 func (db *DB) CreateChirp(body string, userID int) (Chirp, error) {
-	// Load the current database
-	dbStruct, err := db.loadDB()
+	chirp := Chirp{
+		AuthorID: userID,
+		Body:     body,
+	}
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		chirp, err = tx.insertChirp(chirp)
+		return err
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
-	// Generate a unique ID for the chirp
-	id := len(dbStruct.Chirps) + 1
-	// Create the chirp
+
+	return chirp, nil
+}
+
+// CreateReply creates a chirp answering the chirp replyTo, which must exist
+// and not be deleted.
+func (db *DB) CreateReply(body string, userID int, replyTo int) (Chirp, error) {
 	chirp := Chirp{
-		ID:       id,
 		AuthorID: userID,
 		Body:     body,
+		ReplyTo:  &replyTo,
 	}
-	// Add the chirp to the database
-	dbStruct.Chirps[id] = chirp
-	// Write the updated database back to disk
-	err = db.writeDB(dbStruct)
+	err := db.Update(func(tx *Tx) error {
+		parent, ok := tx.Chirp(replyTo)
+		if !ok || !parent.Visible() {
+			return ErrReplyParent
+		}
+		var err error
+		chirp, err = tx.insertChirp(chirp)
+		return err
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
@@ -30,57 +47,442 @@ func (db *DB) CreateChirp(body string, userID int) (Chirp, error) {
 	return chirp, nil
 }
 
-func (db *DB) GetChirps() ([]Chirp, error) {
-	db.mux.RLock()
-	defer db.mux.RUnlock()
-	// Load the current database
-	dbStruct, err := db.loadDB()
+// CreateRechirp reposts the chirp rechirpOf. With an empty body it is a
+// plain rechirp, which a user can make only once per chirp; otherwise it
+// quotes the original. Rechirping a plain rechirp reposts its original.
+func (db *DB) CreateRechirp(body string, userID int, rechirpOf int) (Chirp, error) {
+	chirp := Chirp{
+		AuthorID: userID,
+		Body:     body,
+	}
+	err := db.Update(func(tx *Tx) error {
+		original, ok := tx.Chirp(rechirpOf)
+		if ok && original.IsPlainRechirp() {
+			original, ok = tx.Chirp(*original.RechirpOf)
+		}
+		if !ok || !original.Visible() {
+			return ErrRechirpOriginal
+		}
+		chirp.RechirpOf = &original.ID
+		if chirp.IsPlainRechirp() {
+			if _, ok := tx.plainRechirp(original.ID, userID); ok {
+				return ErrAlreadyRechirped
+			}
+		}
+		var err error
+		chirp, err = tx.insertChirp(chirp)
+		return err
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return chirp, nil
+}
+
+// plainRechirp returns the user's plain rechirp of a chirp, if they have
+// one that isn't deleted.
+func (tx *Tx) plainRechirp(chirpID int, userID int) (Chirp, bool) {
+	for _, id := range tx.RechirpIDs(chirpID) {
+		rechirp, _ := tx.Chirp(id)
+		if rechirp.AuthorID == userID && rechirp.IsPlainRechirp() && rechirp.DeletedAt == nil {
+			return rechirp, true
+		}
+	}
+	return Chirp{}, false
+}
+
+// insertChirp gives a new chirp its ID and timestamps and stores it.
+func (tx *Tx) insertChirp(chirp Chirp) (Chirp, error) {
+	// Generate a unique ID for the chirp
+	id, err := tx.NextID("chirps")
+	if err != nil {
+		return Chirp{}, err
+	}
+	// Never go back in time, even if the clock does: the chirp index
+	// relies on creation times following ID order
+	now := time.Now().UTC()
+	if ids := tx.ChirpIDs(0); len(ids) > 0 {
+		last, _ := tx.Chirp(ids[len(ids)-1])
+		if now.Before(last.CreatedAt) {
+			now = last.CreatedAt
+		}
+	}
+	chirp.ID = id
+	chirp.CreatedAt = now
+	chirp.UpdatedAt = now
+	chirp.Entities = tx.entities(chirp.Body)
+	err = tx.PutChirp(chirp)
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp, _ = tx.Chirp(id)
+	return chirp, nil
+}
+
+// QueryChirps returns one page of chirps. It walks the chirp index from the
+// cursor, so the cost depends on the page size and not on the number of
+// chirps stored.
+func (db *DB) QueryChirps(q ChirpQuery) (ChirpPage, error) {
+	page := ChirpPage{Chirps: []Chirp{}}
+	err := db.View(func(tx *Tx) error {
+		lists := [][]int{}
+		if q.FollowedBy != 0 {
+			for _, follow := range tx.Following(q.FollowedBy) {
+				if q.AuthorID != 0 && follow.FolloweeID != q.AuthorID {
+					continue
+				}
+				lists = append(lists, tx.chirpRange(tx.ChirpIDs(follow.FolloweeID), q))
+			}
+		} else if q.Hashtag != "" {
+			lists = append(lists, tx.chirpRange(tx.HashtagChirpIDs(q.Hashtag), q))
+		} else if q.Mentioning != 0 {
+			lists = append(lists, tx.chirpRange(tx.MentionChirpIDs(q.Mentioning), q))
+		} else {
+			lists = append(lists, tx.chirpRange(tx.ChirpIDs(q.AuthorID), q))
+		}
+		// IDs follow creation times, so merging by ID gives the page order
+		mergeIDs(lists, q.Desc, func(id int) bool {
+			chirp, _ := tx.Chirp(id)
+			if !chirp.Visible() || !q.matches(chirp) {
+				return true
+			}
+			if q.Limit > 0 && len(page.Chirps) == q.Limit {
+				page.Next = cursorFor(page.Chirps[len(page.Chirps)-1])
+				return false
+			}
+			page.Chirps = append(page.Chirps, chirp)
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		return ChirpPage{}, err
+	}
+	return page, nil
+}
+
+// matches checks the filters of q that the list of IDs a page is read from
+// might not have applied.
+func (q ChirpQuery) matches(chirp Chirp) bool {
+	if q.AuthorID != 0 && chirp.AuthorID != q.AuthorID {
+		return false
+	}
+	if q.Hashtag != "" && !containsValue(chirp.Hashtags(), hashtagKey(q.Hashtag)) {
+		return false
+	}
+	if q.Mentioning != 0 && !containsValue(chirp.Mentions(), q.Mentioning) {
+		return false
+	}
+	return true
+}
+
+func containsValue[T comparable](values []T, value T) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// TrendingHashtags counts the chirps created since the given time that use
+// each hashtag, and returns the limit most used, ties broken by tag.
+func (db *DB) TrendingHashtags(since time.Time, limit int) ([]HashtagCount, error) {
+	counts := map[string]int{}
+	err := db.View(func(tx *Tx) error {
+		// Creation times follow ID order, so the window is the tail of
+		// the index
+		ids := tx.chirpRange(tx.ChirpIDs(0), ChirpQuery{Since: since})
+		for _, id := range ids {
+			chirp, _ := tx.chirp(id)
+			if !chirp.Visible() {
+				continue
+			}
+			for _, tag := range chirp.Hashtags() {
+				counts[tag]++
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	// Prepare the chirps slice
-	chirps := make([]Chirp, 0, len(dbStruct.Chirps))
-	// Append chirps to the slice
-	for _, chirp := range dbStruct.Chirps {
-		chirps = append(chirps, chirp)
+	return topHashtags(counts, limit), nil
+}
+
+func topHashtags(counts map[string]int, limit int) []HashtagCount {
+	trending := make([]HashtagCount, 0, len(counts))
+	for tag, count := range counts {
+		trending = append(trending, HashtagCount{Tag: tag, Count: count})
 	}
-	// Sort chirps by ID in ascending order
-	sort.Slice(chirps, func(i, j int) bool {
-		return chirps[i].ID < chirps[j].ID
+	sort.Slice(trending, func(i, j int) bool {
+		if trending[i].Count != trending[j].Count {
+			return trending[i].Count > trending[j].Count
+		}
+		return trending[i].Tag < trending[j].Tag
 	})
+	if limit > 0 && len(trending) > limit {
+		trending = trending[:limit]
+	}
+	return trending
+}
 
-	return chirps, nil
+// chirpRange narrows an ascending list of chirp IDs to those within the
+// query's time range that come after its cursor.
+func (tx *Tx) chirpRange(ids []int, q ChirpQuery) []int {
+	createdAt := func(i int) time.Time {
+		return tx.data.Chirps[ids[i]].CreatedAt
+	}
+	// Creation times follow ID order, so the time range is a slice of the
+	// list
+	lo, hi := 0, len(ids)
+	if !q.Since.IsZero() {
+		lo = sort.Search(len(ids), func(i int) bool {
+			return !createdAt(i).Before(q.Since)
+		})
+	}
+	if !q.Until.IsZero() {
+		hi = sort.Search(len(ids), func(i int) bool {
+			return !createdAt(i).Before(q.Until)
+		})
+	}
+	if q.After != nil {
+		pos := sort.SearchInts(ids, q.After.ID)
+		if q.Desc && pos < hi {
+			hi = pos
+		} else if !q.Desc {
+			if pos < len(ids) && ids[pos] == q.After.ID {
+				pos++
+			}
+			if pos > lo {
+				lo = pos
+			}
+		}
+	}
+	if lo >= hi {
+		return nil
+	}
+	return ids[lo:hi]
 }
 
 func (db *DB) GetChirp(chirpID int) (Chirp, error) {
-	db.mux.RLock()
-	defer db.mux.RUnlock()
-	// Load the current database
-	dbStruct, err := db.loadDB()
+	chirp := Chirp{}
+	err := db.View(func(tx *Tx) error {
+		var ok bool
+		chirp, ok = tx.Chirp(chirpID)
+		if !ok || !chirp.Visible() {
+			return errors.New("The chirp does not exist")
+		}
+		return nil
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
-	chirp := dbStruct.Chirps[chirpID]
-	if chirp.ID == 0 && chirp.Body == "" {
-		return Chirp{}, errors.New("The chirp does not exist")
+	return chirp, nil
+}
+
+// GetChirpThread returns a chirp with its replies, and their replies, down
+// to maxDepth levels. Deleted replies are left out along with everything
+// below them.
+func (db *DB) GetChirpThread(chirpID int, maxDepth int) (ChirpThread, error) {
+	thread := ChirpThread{}
+	err := db.View(func(tx *Tx) error {
+		root, ok := tx.Chirp(chirpID)
+		if !ok || !root.Visible() {
+			return errors.New("The chirp does not exist")
+		}
+		thread = tx.thread(root, maxDepth)
+		return nil
+	})
+	if err != nil {
+		return ChirpThread{}, err
+	}
+	return thread, nil
+}
+
+func (tx *Tx) thread(chirp Chirp, depth int) ChirpThread {
+	thread := ChirpThread{Chirp: chirp, Replies: []ChirpThread{}}
+	if depth == 0 {
+		return thread
+	}
+	for _, replyID := range tx.ReplyIDs(chirp.ID) {
+		reply, _ := tx.Chirp(replyID)
+		if !reply.Visible() {
+			continue
+		}
+		thread.Replies = append(thread.Replies, tx.thread(reply, depth-1))
+	}
+	return thread
+}
+
+// UpdateChirp replaces the body of one of the user's chirps, keeping the
+// old body as a revision.
+func (db *DB) UpdateChirp(chirpID int, userID int, body string) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		chirp, ok = tx.Chirp(chirpID)
+		if !ok || !chirp.Visible() || chirp.AuthorID != userID {
+			return errors.New("The chirp to be updated does not exist")
+		}
+		if chirp.IsPlainRechirp() {
+			return errors.New("A plain rechirp cannot be edited")
+		}
+		if chirp.Body == body {
+			return nil
+		}
+		now := time.Now().UTC()
+		err := tx.AddRevision(ChirpRevision{
+			ChirpID:    chirp.ID,
+			Revision:   len(tx.Revisions(chirp.ID)) + 1,
+			Body:       chirp.Body,
+			CreatedAt:  chirp.UpdatedAt,
+			ReplacedAt: now,
+		})
+		if err != nil {
+			return err
+		}
+		chirp.Body = body
+		chirp.UpdatedAt = now
+		chirp.Entities = tx.entities(body)
+		return tx.PutChirp(chirp)
+	})
+	if err != nil {
+		return Chirp{}, err
 	}
 	return chirp, nil
 }
 
-func (db *DB) DeleteChirp(chirpID int, userId int) error {
-	// Load the current database
-	dbStruct, err := db.loadDB()
+// GetChirpRevisions returns the earlier bodies of a chirp, oldest first.
+func (db *DB) GetChirpRevisions(chirpID int) ([]ChirpRevision, error) {
+	revisions := []ChirpRevision{}
+	err := db.View(func(tx *Tx) error {
+		chirp, ok := tx.Chirp(chirpID)
+		if !ok || !chirp.Visible() {
+			return errors.New("The chirp does not exist")
+		}
+		revisions = append(revisions, tx.Revisions(chirpID)...)
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	chirp := dbStruct.Chirps[chirpID]
-	if (chirp.ID == 0 && chirp.Body == "") || chirp.AuthorID != userId {
-		return errors.New("The chirp to be deleted does not exist")
+	return revisions, nil
+}
+
+// DeleteChirp moves a chirp to the trash. It stays there until its author
+// restores it or PurgeDeletedChirps removes it for good.
+func (db *DB) DeleteChirp(chirpID int, userId int) error {
+	return db.Update(func(tx *Tx) error {
+		chirp, ok := tx.Chirp(chirpID)
+		if !ok || chirp.DeletedAt != nil || chirp.AuthorID != userId {
+			return errors.New("The chirp to be deleted does not exist")
+		}
+		deletedAt := time.Now().UTC()
+		chirp.DeletedAt = &deletedAt
+		if err := tx.PutChirp(chirp); err != nil {
+			return err
+		}
+		// Plain rechirps have nothing left to show without the original,
+		// so they go with it. Quotes stay, without the original.
+		for _, id := range tx.RechirpIDs(chirpID) {
+			rechirp, _ := tx.Chirp(id)
+			if !rechirp.IsPlainRechirp() || rechirp.DeletedAt != nil {
+				continue
+			}
+			rechirp.DeletedAt = &deletedAt
+			if err := tx.PutChirp(rechirp); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RestoreChirp takes a chirp back out of the trash, provided it was deleted
+// after deletedAfter.
+func (db *DB) RestoreChirp(chirpID int, userID int, deletedAfter time.Time) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		chirp, ok = tx.Chirp(chirpID)
+		if !ok || chirp.DeletedAt == nil || chirp.AuthorID != userID {
+			return errors.New("The chirp to be restored does not exist")
+		}
+		if chirp.DeletedAt.Before(deletedAfter) {
+			return ErrRestoreExpired
+		}
+		if chirp.IsPlainRechirp() {
+			if chirp.Original == nil {
+				return ErrRechirpOriginal
+			}
+			if _, ok := tx.plainRechirp(*chirp.RechirpOf, userID); ok {
+				return ErrAlreadyRechirped
+			}
+		}
+		deletedAt := *chirp.DeletedAt
+		chirp.DeletedAt = nil
+		if err := tx.PutChirp(chirp); err != nil {
+			return err
+		}
+		// Bring back the plain rechirps that were deleted along with it
+		for _, id := range tx.RechirpIDs(chirpID) {
+			rechirp, _ := tx.Chirp(id)
+			if !rechirp.IsPlainRechirp() || rechirp.DeletedAt == nil || !rechirp.DeletedAt.Equal(deletedAt) {
+				continue
+			}
+			rechirp.DeletedAt = nil
+			if err := tx.PutChirp(rechirp); err != nil {
+				return err
+			}
+		}
+		chirp, _ = tx.Chirp(chirpID)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
 	}
-	dbStruct.Chirps[chirpID] = Chirp{}
-	err = db.writeDB(dbStruct)
+	return chirp, nil
+}
+
+// PurgeDeletedChirps permanently removes the chirps deleted before
+// deletedBefore and reports how many there were.
+func (db *DB) PurgeDeletedChirps(deletedBefore time.Time) (int, error) {
+	purged := 0
+	err := db.Update(func(tx *Tx) error {
+		reports := tx.Reports()
+		for _, chirp := range tx.Chirps() {
+			if chirp.DeletedAt == nil || !chirp.DeletedAt.Before(deletedBefore) {
+				continue
+			}
+			if err := tx.DeleteChirp(chirp.ID); err != nil {
+				return err
+			}
+			if err := tx.DeleteRevisions(chirp.ID); err != nil {
+				return err
+			}
+			// Copy the user IDs, as deleting the likes updates the index
+			userIDs := append([]int(nil), tx.ChirpLikes(chirp.ID)...)
+			for _, userID := range userIDs {
+				if err := tx.DeleteLike(chirp.ID, userID); err != nil {
+					return err
+				}
+			}
+			for _, report := range reports {
+				if report.ChirpID != chirp.ID {
+					continue
+				}
+				if err := tx.DeleteReport(report.ID); err != nil {
+					return err
+				}
+			}
+			purged++
+		}
+		return nil
+	})
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	return purged, nil
 }
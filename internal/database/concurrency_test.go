@@ -0,0 +1,286 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// These tests are meant to be run with -race. Each one starts many
+// goroutines at once against the same store and checks that no update was
+// lost and no ID handed out twice.
+
+const workers = 32
+
+// parallel runs fn(i) for i in [0, n) on n goroutines released at the same
+// moment, and reports the first error any of them returned.
+func parallel(t *testing.T, n int, fn func(i int) error) {
+	t.Helper()
+	start := make(chan struct{})
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs <- fn(i)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConcurrentCreateChirp(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func() Store) {
+		db := open()
+		user := mustCreateUser(t, db, "author@example.com")
+
+		const perWorker = 5
+		var mux sync.Mutex
+		ids := map[int]string{}
+		parallel(t, workers, func(i int) error {
+			for j := 0; j < perWorker; j++ {
+				body := fmt.Sprintf("chirp %d/%d", i, j)
+				chirp, err := db.CreateChirp(body, user.ID)
+				if err != nil {
+					return err
+				}
+				mux.Lock()
+				other, dup := ids[chirp.ID]
+				ids[chirp.ID] = body
+				mux.Unlock()
+				if dup {
+					return fmt.Errorf("ID %d handed out to both %q and %q", chirp.ID, other, body)
+				}
+			}
+			return nil
+		})
+
+		page, err := db.QueryChirps(ChirpQuery{AuthorID: user.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Chirps) != workers*perWorker {
+			t.Fatalf("got %d chirps, want %d", len(page.Chirps), workers*perWorker)
+		}
+		for _, chirp := range page.Chirps {
+			if ids[chirp.ID] != chirp.Body {
+				t.Errorf("chirp %d has body %q, want %q", chirp.ID, chirp.Body, ids[chirp.ID])
+			}
+		}
+	})
+}
+
+func TestConcurrentCreateUser(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func() Store) {
+		db := open()
+
+		var mux sync.Mutex
+		ids := map[int]bool{}
+		created := 0
+		parallel(t, workers, func(i int) error {
+			user, err := db.CreateUser(fmt.Sprintf("user%d@example.com", i), []byte("hash"))
+			if err != nil {
+				return err
+			}
+			// Everyone also races for the same address; only one may get it
+			_, err = db.CreateUser("same@example.com", []byte("hash"))
+			mux.Lock()
+			defer mux.Unlock()
+			if ids[user.ID] {
+				return fmt.Errorf("user ID %d handed out twice", user.ID)
+			}
+			ids[user.ID] = true
+			if err == nil {
+				created++
+			}
+			return nil
+		})
+
+		if created != 1 {
+			t.Errorf("same email created %d times, want once", created)
+		}
+	})
+}
+
+func TestConcurrentLikeChirp(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func() Store) {
+		db := open()
+		author := mustCreateUser(t, db, "author@example.com")
+		chirp := mustCreateChirp(t, db, "like me", author.ID)
+		users := make([]User, workers)
+		for i := range users {
+			users[i] = mustCreateUser(t, db, fmt.Sprintf("fan%d@example.com", i))
+		}
+
+		// Liking twice counts once, so every user liking twice must still
+		// add up to one like per user
+		parallel(t, workers*2, func(i int) error {
+			_, err := db.LikeChirp(chirp.ID, users[i%workers].ID)
+			return err
+		})
+
+		got, err := db.GetChirp(chirp.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.LikeCount != workers {
+			t.Fatalf("got %d likes, want %d", got.LikeCount, workers)
+		}
+		for _, user := range users {
+			liked, err := db.GetLikedChirps(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(liked) != 1 || liked[0].ID != chirp.ID {
+				t.Fatalf("user %d likes %v, want chirp %d", user.ID, liked, chirp.ID)
+			}
+		}
+	})
+}
+
+func TestConcurrentUpdateUser(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func() Store) {
+		db := open()
+		user := mustCreateUser(t, db, "user@example.com")
+		others := make([]User, workers)
+		for i := range others {
+			others[i] = mustCreateUser(t, db, fmt.Sprintf("other%d@example.com", i))
+		}
+
+		// The user changes their email while the others all race for the
+		// same new address and the user is upgraded. An update that wrote
+		// back what it read before another update would lose the upgrade.
+		var mux sync.Mutex
+		taken := 0
+		parallel(t, workers+2, func(i int) error {
+			switch i {
+			case workers:
+				_, err := db.UpdateUser(user.ID, "new@example.com", []byte("new hash"))
+				return err
+			case workers + 1:
+				_, err := db.UpgradeUserStatus(user.ID)
+				return err
+			}
+			_, err := db.UpdateUser(others[i].ID, "same@example.com", []byte("hash"))
+			if errors.Is(err, ErrAlreadyExists) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			mux.Lock()
+			taken++
+			mux.Unlock()
+			return nil
+		})
+
+		if taken != 1 {
+			t.Errorf("same email taken %d times, want once", taken)
+		}
+		got, err := db.GetUserByEmail("new@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != user.ID || string(got.Hash) != "new hash" || !got.IsChirpyRed {
+			t.Fatalf("got user %+v, want both the new email and the upgrade", got)
+		}
+
+		// Each update bumps the token version by one, so any update that
+		// overwrote another's read would leave the count short
+		version := got.TokenVersion
+		parallel(t, workers, func(i int) error {
+			_, err := db.UpdateUser(user.ID, fmt.Sprintf("user%d@example.com", i), []byte("hash"))
+			return err
+		})
+		got, err = db.GetUser(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.TokenVersion != version+workers {
+			t.Fatalf("got token version %d, want %d", got.TokenVersion, version+workers)
+		}
+	})
+}
+
+func TestConcurrentRotateSession(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func() Store) {
+		db := open()
+		user := mustCreateUser(t, db, "user@example.com")
+		expires := time.Now().Add(time.Hour)
+		if _, err := db.CreateSession(Session{UserID: user.ID, TokenHash: "token", ExpiresAt: expires}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Everyone racing to rotate the same token but one is replaying a
+		// token that was already rotated out
+		var mux sync.Mutex
+		rotated := 0
+		parallel(t, workers, func(i int) error {
+			_, err := db.RotateSession("token", Session{TokenHash: fmt.Sprintf("token %d", i), ExpiresAt: expires})
+			if errors.Is(err, ErrTokenReused) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			mux.Lock()
+			rotated++
+			mux.Unlock()
+			return nil
+		})
+
+		if rotated != 1 {
+			t.Errorf("token rotated %d times, want once", rotated)
+		}
+	})
+}
+
+func TestConcurrentUpdateChirp(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func() Store) {
+		db := open()
+		user := mustCreateUser(t, db, "author@example.com")
+		chirp := mustCreateChirp(t, db, "original", user.ID)
+
+		parallel(t, workers, func(i int) error {
+			_, err := db.UpdateChirp(chirp.ID, user.ID, fmt.Sprintf("edit %d", i))
+			return err
+		})
+
+		// Every edit keeps the body it replaced, so there is one revision
+		// per edit, numbered without gaps or repeats
+		revisions, err := db.GetChirpRevisions(chirp.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revisions) != workers {
+			t.Fatalf("got %d revisions, want %d", len(revisions), workers)
+		}
+		seen := map[int]bool{}
+		bodies := map[string]bool{}
+		for _, revision := range revisions {
+			if seen[revision.Revision] {
+				t.Errorf("revision %d recorded twice", revision.Revision)
+			}
+			seen[revision.Revision] = true
+			bodies[revision.Body] = true
+		}
+		got, err := db.GetChirp(chirp.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies[got.Body] = true
+		if !bodies["original"] || len(bodies) != workers+1 {
+			t.Errorf("got %d distinct bodies across the chirp and its revisions, want %d", len(bodies), workers+1)
+		}
+	})
+}
@@ -3,87 +3,323 @@ package database
 import (
 	"encoding/json"
 	"errors"
+	"log"
 	"os"
 	"sync"
 	"time"
 )
 
+// DB is a Store that keeps the whole database in memory and persists it to
+// a JSON snapshot plus journal (see journal.go). Reads never touch the
+// disk; committed mutations are queued and written out by a background
+// flusher every flushInterval, or before Update returns when the interval
+// is zero.
 type DB struct {
-	path string
-	mux  *sync.RWMutex
+	path    string
+	mux     *sync.RWMutex
+	data    DBStructure
+	chirps  *chirpIndex
+	likes   *likeIndex
+	follows *followIndex
+	search  *searchIndex
+	// pending holds committed journal entries that are not yet on disk.
+	// It is guarded by mux.
+	pending []walEntry
+
+	// flushMux serializes flushes and guards journalLen.
+	flushMux      sync.Mutex
+	journalLen    int
+	compactEvery  int
+	flushInterval time.Duration
+
+	done      chan struct{}
+	flusher   sync.WaitGroup
+	closeOnce sync.Once
 }
 
 type Chirp struct {
-	ID       int    `json:"id"`
-	AuthorID int    `json:"author_id"`
+	ID        int       `json:"id"`
+	AuthorID  int       `json:"author_id"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt is set while the chirp sits in the trash, waiting to be
+	// restored or purged
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// HiddenAt is set while a moderator keeps the chirp out of sight
+	HiddenAt *time.Time `json:"hidden_at,omitempty"`
+	// ReplyTo is the ID of the chirp this one answers, if any
+	ReplyTo *int `json:"reply_to,omitempty"`
+	// ReplyCount is the number of replies that haven't been deleted. It is
+	// derived on read and never stored.
+	ReplyCount int `json:"-"`
+	// RechirpOf is the ID of the chirp this one reposts. A rechirp with an
+	// empty body is a plain repost; one with a body quotes the original.
+	RechirpOf *int `json:"rechirp_of,omitempty"`
+	// Entities are the hashtags and mentions in Body, in order
+	Entities []Entity `json:"entities,omitempty"`
+	// LikeCount is derived on read, like ReplyCount
+	LikeCount int `json:"-"`
+	// Original is the chirp RechirpOf points at, filled in on read unless
+	// it has been deleted
+	Original *Chirp `json:"-"`
+	// Author is filled in on read
+	Author Author `json:"-"`
+}
+
+// Visible reports whether the chirp is neither deleted nor hidden.
+func (chirp Chirp) Visible() bool {
+	return chirp.DeletedAt == nil && chirp.HiddenAt == nil
+}
+
+// IsPlainRechirp reports whether the chirp reposts another without adding
+// anything of its own.
+func (chirp Chirp) IsPlainRechirp() bool {
+	return chirp.RechirpOf != nil && chirp.Body == ""
+}
+
+// Follow records that one user follows another.
+type Follow struct {
+	FollowerID int       `json:"follower_id"`
+	FolloweeID int       `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Like records that a user likes a chirp.
+type Like struct {
+	ChirpID   int       `json:"chirp_id"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChirpRevision is an earlier body of an edited chirp.
+type ChirpRevision struct {
+	ChirpID  int    `json:"chirp_id"`
+	Revision int    `json:"revision"`
 	Body     string `json:"body"`
+	// CreatedAt is when this body was written, ReplacedAt when it was
+	// edited away
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
 }
 
 type User struct {
-	ID          int    `json:"id"`
-	Email       string `json:"email"`
-	Hash        []byte `json:"hash"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	ID          int       `json:"id"`
+	Email       string    `json:"email"`
+	Hash        []byte    `json:"hash"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+	Role        string    `json:"role"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// SuspendedAt is set while the user is barred from logging in and
+	// chirping
+	SuspendedAt *time.Time `json:"suspended_at,omitempty"`
+	// TokenVersion goes up whenever the user's credentials change; tokens
+	// issued for an older version are no longer accepted
+	TokenVersion int `json:"token_version"`
+	Profile
+}
+
+// The roles a user can have. Moderators work the report queue; admins can
+// also change roles.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Profile is the public part of a user.
+type Profile struct {
+	// Handle is unique, ignoring case, or empty if the user has none
+	Handle      string `json:"handle,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Bio         string `json:"bio,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
 }
 
+// Author is the compact view of a chirp's author that goes with the chirp.
+type Author struct {
+	ID          int
+	Handle      string
+	DisplayName string
+	AvatarURL   string
+}
+
+// Report is a user's complaint about a chirp, waiting in the moderation
+// queue until it is resolved.
+type Report struct {
+	ID      int `json:"id"`
+	ChirpID int `json:"chirp_id"`
+	// ReporterID is zero for chirps flagged by the moderation rules
+	ReporterID int        `json:"reporter_id"`
+	Reason     string     `json:"reason"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// Session is a login on one device. Only the hash of its refresh token is
+// stored; each refresh replaces the token, and the one it replaces is kept
+// as a RevokedToken so that a replay of it can be caught.
+type Session struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	TokenHash  string    `json:"token_hash"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// RevokedToken is a refresh token that can no longer be used, keyed by its
+// hash. Once the token itself has expired the record serves no purpose and
+// PurgeExpiredTokens removes it.
 type RevokedToken struct {
 	ID        string    `json:"id"`
 	RevokedAt time.Time `json:"revoked_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// SessionID is the session the token was rotated out of
+	SessionID int `json:"session_id,omitempty"`
+}
+
+// TokenStats counts the records kept for refresh tokens.
+type TokenStats struct {
+	Sessions      int
+	RevokedTokens int
 }
 
 var ErrAlreadyExists = errors.New("User already exists")
 
+var ErrRestoreExpired = errors.New("Chirp can no longer be restored")
+
+var ErrReplyParent = errors.New("The chirp being replied to does not exist")
+
+var ErrRechirpOriginal = errors.New("The chirp being rechirped does not exist")
+
+var ErrAlreadyRechirped = errors.New("Chirp already rechirped")
+
+var ErrFollowSelf = errors.New("Users cannot follow themselves")
+
+var ErrHandleTaken = errors.New("Handle already taken")
+
+var ErrAlreadyReported = errors.New("Chirp already reported")
+
+var ErrSessionNotFound = errors.New("Session not found")
+
+var ErrTokenReused = errors.New("Refresh token already used")
+
 type DBStructure struct {
+	// Version is the number of jsonMigrations applied to the file
+	Version       int                     `json:"version"`
 	Chirps        map[int]Chirp           `json:"chirps"`
 	Users         map[int]User            `json:"users"`
 	RevokedTokens map[string]RevokedToken `json:"tokens"`
+	// Revisions holds each chirp's earlier bodies, oldest first
+	Revisions map[int][]ChirpRevision `json:"revisions"`
+	// Sequences holds the last ID handed out per collection
+	Sequences map[string]int `json:"sequences"`
+	// Likes is keyed by likeKey
+	Likes map[string]Like `json:"likes"`
+	// Follows is keyed by followKey
+	Follows  map[string]Follow `json:"follows"`
+	Reports  map[int]Report    `json:"reports"`
+	Sessions map[int]Session   `json:"sessions"`
+}
+
+// ensureMaps allocates any collection missing from the file, so that
+// transactions can always write to it.
+func (dbStruct *DBStructure) ensureMaps() {
+	if dbStruct.Chirps == nil {
+		dbStruct.Chirps = make(map[int]Chirp)
+	}
+	if dbStruct.Users == nil {
+		dbStruct.Users = make(map[int]User)
+	}
+	if dbStruct.RevokedTokens == nil {
+		dbStruct.RevokedTokens = make(map[string]RevokedToken)
+	}
+	if dbStruct.Revisions == nil {
+		dbStruct.Revisions = make(map[int][]ChirpRevision)
+	}
+	if dbStruct.Sequences == nil {
+		dbStruct.Sequences = make(map[string]int)
+	}
+	if dbStruct.Likes == nil {
+		dbStruct.Likes = make(map[string]Like)
+	}
+	if dbStruct.Follows == nil {
+		dbStruct.Follows = make(map[string]Follow)
+	}
+	if dbStruct.Reports == nil {
+		dbStruct.Reports = make(map[int]Report)
+	}
+	if dbStruct.Sessions == nil {
+		dbStruct.Sessions = make(map[int]Session)
+	}
+}
+
+// buildIndexes rebuilds the in-memory indexes from db.data.
+func (db *DB) buildIndexes() {
+	db.chirps = newChirpIndex(db.data.Chirps)
+	db.likes = newLikeIndex(db.data.Likes)
+	db.follows = newFollowIndex(db.data.Follows)
+	db.search = newSearchIndex(db.data.Chirps)
 }
 
 func NewDB(path string) (*DB, error) {
-	// Initialize the mutex
-	mux := &sync.RWMutex{}
-	// Create an empty DBStructure
-	dbStruct := DBStructure{}
+	return newJSONDB(Config{Path: path})
+}
+
+func newJSONDB(cfg Config) (*DB, error) {
+	db := &DB{
+		path:          cfg.Path,
+		mux:           &sync.RWMutex{},
+		compactEvery:  cfg.CompactEvery,
+		flushInterval: cfg.FlushInterval,
+		done:          make(chan struct{}),
+	}
+	if db.compactEvery <= 0 {
+		db.compactEvery = defaultCompactEvery
+	}
 
-	// Read the database file
-	data, err := os.ReadFile(path)
+	// Create an empty database file if it doesn't exist
+	err := db.ensureDB()
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			// Create an empty database file if it doesn't exist
-			data, err = createEmptyDatabaseFile(path)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			return nil, err
-		}
+		return nil, err
 	}
-	// Unmarshall the data - with data or otherwise
-	err = json.Unmarshal(data, &dbStruct)
+	// Read the snapshot and journal once; from here on memory is the source
+	// of truth
+	db.data, err = db.loadDB()
 	if err != nil {
 		return nil, err
 	}
+	db.buildIndexes()
+	// Fold whatever the journal holds into a fresh snapshot
+	err = db.compact()
+	if err != nil {
+		return nil, err
+	}
+
+	if db.flushInterval > 0 {
+		db.flusher.Add(1)
+		go db.flushLoop()
+	}
 
-	// Return the new DB instance
-	return &DB{
-		path: path,
-		mux:  mux,
-	}, nil
+	return db, nil
 }
 
 func createEmptyDatabaseFile(path string) ([]byte, error) {
 	emptyDB := DBStructure{
-		Chirps:        make(map[int]Chirp),
-		Users:         make(map[int]User),
-		RevokedTokens: make(map[string]RevokedToken),
+		Version: len(jsonMigrations),
 	}
+	emptyDB.ensureMaps()
 
 	data, err := json.MarshalIndent(emptyDB, "", "  ")
 	if err != nil {
 		return []byte{}, err
 	}
 
-	err = os.WriteFile(path, data, 0644)
+	err = writeFileAtomic(path, data)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -100,47 +336,201 @@ func (db *DB) ensureDB() error {
 	return err
 }
 
+// loadDB reads the last snapshot and replays the journal on top of it.
 func (db *DB) loadDB() (DBStructure, error) {
 	data, err := os.ReadFile(db.path)
 	if err != nil {
 		return DBStructure{}, err
 	}
+	entries, err := db.readJournal()
+	if err != nil {
+		return DBStructure{}, err
+	}
+	data, err = replayJournal(data, entries)
+	if err != nil {
+		return DBStructure{}, err
+	}
 
 	dbStruct := DBStructure{}
 	err = json.Unmarshal(data, &dbStruct)
 	if err != nil {
 		return DBStructure{}, err
 	}
+	dbStruct.ensureMaps()
+	err = migrateJSON(&dbStruct)
+	if err != nil {
+		return DBStructure{}, err
+	}
 
 	return dbStruct, nil
 }
 
-func (db *DB) writeDB(dbStructure DBStructure) error {
+// flushLoop writes pending mutations to disk every flushInterval until the
+// database is closed.
+func (db *DB) flushLoop() {
+	defer db.flusher.Done()
+	ticker := time.NewTicker(db.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := db.flush()
+			if err != nil {
+				log.Printf("Error flushing database: %s", err)
+			}
+		case <-db.done:
+			return
+		}
+	}
+}
+
+// flush appends the pending mutations to the journal, or rewrites the
+// snapshot instead once the journal has grown past compactEvery entries.
+func (db *DB) flush() error {
+	db.flushMux.Lock()
+	defer db.flushMux.Unlock()
+
+	db.mux.Lock()
+	entries := db.pending
+	db.pending = nil
+	if len(entries) == 0 {
+		db.mux.Unlock()
+		return nil
+	}
+	if db.journalLen+len(entries) >= db.compactEvery {
+		// The snapshot already contains the pending entries
+		data, err := db.encodeSnapshot()
+		db.mux.Unlock()
+		if err == nil {
+			err = db.writeSnapshot(data)
+		}
+		if err != nil {
+			db.requeue(entries)
+			return err
+		}
+		return nil
+	}
+	db.mux.Unlock()
+
+	err := db.appendJournal(entries)
+	if err != nil {
+		db.requeue(entries)
+		return err
+	}
+	db.journalLen += len(entries)
+	return nil
+}
+
+// writeEntries appends entries, behind any still pending, to the journal
+// and compacts it once it has grown past compactEvery entries. The caller
+// must hold flushMux and mux. The entries are on disk once the append
+// succeeds; a failed compaction is only logged and tried again next time.
+func (db *DB) writeEntries(entries []walEntry) error {
+	entries = append(db.pending[:len(db.pending):len(db.pending)], entries...)
+	err := db.appendJournal(entries)
+	if err != nil {
+		return err
+	}
+	db.pending = nil
+	db.journalLen += len(entries)
+	if db.journalLen < db.compactEvery {
+		return nil
+	}
+	data, err := db.encodeSnapshot()
+	if err == nil {
+		err = db.writeSnapshot(data)
+	}
+	if err != nil {
+		log.Printf("Error compacting database: %s", err)
+	}
+	return nil
+}
+
+// requeue puts entries that failed to flush back in front of any entries
+// committed in the meantime, so the next flush retries them in order.
+func (db *DB) requeue(entries []walEntry) {
 	db.mux.Lock()
 	defer db.mux.Unlock()
+	db.pending = append(entries, db.pending...)
+}
 
-	data, err := json.MarshalIndent(dbStructure, "", "  ")
+// compact writes the in-memory state as the new snapshot and drops the
+// journal, whatever its length.
+func (db *DB) compact() error {
+	db.flushMux.Lock()
+	defer db.flushMux.Unlock()
+
+	db.mux.Lock()
+	entries := db.pending
+	db.pending = nil
+	data, err := db.encodeSnapshot()
+	db.mux.Unlock()
+	if err == nil {
+		err = db.writeSnapshot(data)
+	}
 	if err != nil {
+		db.requeue(entries)
 		return err
 	}
+	return nil
+}
+
+// encodeSnapshot marshals the in-memory state. The caller must hold mux.
+func (db *DB) encodeSnapshot() ([]byte, error) {
+	return json.MarshalIndent(db.data, "", "  ")
+}
 
-	err = os.WriteFile(db.path, data, 0644)
+// writeSnapshot replaces the snapshot and drops the journal. The caller
+// must hold flushMux.
+func (db *DB) writeSnapshot(data []byte) error {
+	err := writeFileAtomic(db.path, data)
 	if err != nil {
 		return err
 	}
+	err = db.truncateJournal()
+	if err != nil {
+		return err
+	}
+	db.journalLen = 0
 
 	return nil
 }
 
 func (db *DB) ResetDB() error {
-	path := db.path
-	if err := os.Remove(path); err != nil {
+	db.flushMux.Lock()
+	defer db.flushMux.Unlock()
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := createEmptyDatabaseFile(db.path)
+	if err != nil {
 		return err
 	}
-	newDB, err := NewDB(path)
+	dbStruct := DBStructure{}
+	err = json.Unmarshal(data, &dbStruct)
 	if err != nil {
 		return err
 	}
-	db = newDB
+	dbStruct.ensureMaps()
+	db.data = dbStruct
+	db.buildIndexes()
+	db.pending = nil
+
+	err = db.truncateJournal()
+	if err != nil {
+		return err
+	}
+	db.journalLen = 0
 	return nil
 }
+
+// Close stops the background flusher and writes everything still in memory
+// to a fresh snapshot. The server must call it on shutdown, or mutations
+// made within the last flush interval are lost.
+func (db *DB) Close() error {
+	db.closeOnce.Do(func() {
+		close(db.done)
+	})
+	db.flusher.Wait()
+	return db.compact()
+}
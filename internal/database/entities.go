@@ -0,0 +1,133 @@
+package database
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	EntityHashtag = "hashtag"
+	EntityMention = "mention"
+)
+
+// Entity is a hashtag or mention found in a chirp's body. Start and End are
+// byte offsets into the body, End exclusive, and span the leading # or @.
+type Entity struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	// UserID is the mentioned user, resolved from the handle when the
+	// chirp was written
+	UserID int `json:"user_id,omitempty"`
+}
+
+// HashtagCount is the number of chirps that used a hashtag.
+type HashtagCount struct {
+	Tag   string
+	Count int
+}
+
+// hashtagKey is the form hashtags are matched by, so that #Go and #go are
+// the same tag.
+func hashtagKey(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+// Hashtags returns the distinct hashtags of the chirp, as hashtagKeys.
+func (chirp Chirp) Hashtags() []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, entity := range chirp.Entities {
+		if entity.Type != EntityHashtag {
+			continue
+		}
+		key := hashtagKey(entity.Text)
+		if !seen[key] {
+			seen[key] = true
+			tags = append(tags, key)
+		}
+	}
+	return tags
+}
+
+// Mentions returns the distinct IDs of the users the chirp mentions.
+func (chirp Chirp) Mentions() []int {
+	userIDs := []int{}
+	seen := map[int]bool{}
+	for _, entity := range chirp.Entities {
+		if entity.Type == EntityMention && !seen[entity.UserID] {
+			seen[entity.UserID] = true
+			userIDs = append(userIDs, entity.UserID)
+		}
+	}
+	return userIDs
+}
+
+// parseEntities finds the hashtags and mentions in a chirp body. A # or @
+// only starts one at the beginning of the body or after a character that
+// can't be part of a word, so e-mail addresses and URL fragments are left
+// alone. A hashtag needs at least one letter; a mention must name the
+// handle of an existing user, which userID looks up, returning zero when
+// there is none.
+func parseEntities(body string, userID func(handle string) (int, error)) ([]Entity, error) {
+	var entities []Entity
+	prev := ' '
+	for i := 0; i < len(body); {
+		r, size := utf8.DecodeRuneInString(body[i:])
+		if (r != '#' && r != '@') || isWordRune(prev) {
+			prev = r
+			i += size
+			continue
+		}
+		start := i
+		end := i + size
+		hasLetter := false
+		for end < len(body) {
+			next, nextSize := utf8.DecodeRuneInString(body[end:])
+			if !isWordRune(next) {
+				break
+			}
+			if unicode.IsLetter(next) {
+				hasLetter = true
+			}
+			end += nextSize
+		}
+		text := body[start+size : end]
+		switch {
+		case r == '#' && hasLetter:
+			entities = append(entities, Entity{Type: EntityHashtag, Text: text, Start: start, End: end})
+		case r == '@' && isHandle(text):
+			id, err := userID(text)
+			if err != nil {
+				return nil, err
+			}
+			if id != 0 {
+				entities = append(entities, Entity{Type: EntityMention, Text: text, Start: start, End: end, UserID: id})
+			}
+		}
+		prev, _ = utf8.DecodeLastRuneInString(body[:end])
+		i = end
+	}
+	return entities, nil
+}
+
+// isHandle reports whether s could be a handle: 3 to 15 ASCII letters,
+// digits or underscores.
+func isHandle(s string) bool {
+	if len(s) < 3 || len(s) > 15 {
+		return false
+	}
+	for _, r := range s {
+		if r > unicode.MaxASCII || !isWordRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isWordRune reports whether r can be part of a hashtag or handle.
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// FollowUser makes followerID follow followeeID. Following someone twice is
+// the same as following them once.
+func (db *DB) FollowUser(followerID int, followeeID int) error {
+	if followerID == followeeID {
+		return ErrFollowSelf
+	}
+	return db.Update(func(tx *Tx) error {
+		if _, ok := tx.User(followeeID); !ok {
+			return errors.New("User does not exist")
+		}
+		if _, ok := tx.Follow(followerID, followeeID); ok {
+			return nil
+		}
+		return tx.PutFollow(Follow{
+			FollowerID: followerID,
+			FolloweeID: followeeID,
+			CreatedAt:  time.Now().UTC(),
+		})
+	})
+}
+
+// UnfollowUser stops followerID following followeeID, if they do.
+func (db *DB) UnfollowUser(followerID int, followeeID int) error {
+	return db.Update(func(tx *Tx) error {
+		if _, ok := tx.User(followeeID); !ok {
+			return errors.New("User does not exist")
+		}
+		return tx.DeleteFollow(followerID, followeeID)
+	})
+}
+
+// GetFollowers returns who follows a user, most recent first.
+func (db *DB) GetFollowers(userID int) ([]Follow, error) {
+	follows := []Follow{}
+	err := db.View(func(tx *Tx) error {
+		if _, ok := tx.User(userID); !ok {
+			return errors.New("User does not exist")
+		}
+		followers := tx.Followers(userID)
+		for i := len(followers) - 1; i >= 0; i-- {
+			follows = append(follows, followers[i])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return follows, nil
+}
+
+// GetFollowing returns who a user follows, most recent first.
+func (db *DB) GetFollowing(userID int) ([]Follow, error) {
+	follows := []Follow{}
+	err := db.View(func(tx *Tx) error {
+		if _, ok := tx.User(userID); !ok {
+			return errors.New("User does not exist")
+		}
+		following := tx.Following(userID)
+		for i := len(following) - 1; i >= 0; i-- {
+			follows = append(follows, following[i])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return follows, nil
+}
@@ -0,0 +1,182 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// purgeAll removes every chirp in the trash for good.
+func purgeAll(t *testing.T, db Store) {
+	t.Helper()
+	if _, err := db.PurgeDeletedChirps(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIDsNotReused(t *testing.T) {
+	tests := []struct {
+		name string
+		// forget removes the newest chirp, returning the store to use next
+		forget func(t *testing.T, db Store, open func() Store, chirp Chirp) Store
+	}{
+		{
+			name: "delete then create",
+			forget: func(t *testing.T, db Store, open func() Store, chirp Chirp) Store {
+				if err := db.DeleteChirp(chirp.ID, chirp.AuthorID); err != nil {
+					t.Fatal(err)
+				}
+				return db
+			},
+		},
+		{
+			name: "delete, close and reopen then create",
+			forget: func(t *testing.T, db Store, open func() Store, chirp Chirp) Store {
+				if err := db.DeleteChirp(chirp.ID, chirp.AuthorID); err != nil {
+					t.Fatal(err)
+				}
+				if err := db.Close(); err != nil {
+					t.Fatal(err)
+				}
+				return open()
+			},
+		},
+		{
+			name: "purge then create",
+			forget: func(t *testing.T, db Store, open func() Store, chirp Chirp) Store {
+				if err := db.DeleteChirp(chirp.ID, chirp.AuthorID); err != nil {
+					t.Fatal(err)
+				}
+				purgeAll(t, db)
+				if _, err := db.GetChirp(chirp.ID); err == nil {
+					t.Fatalf("chirp %d is still there after purging", chirp.ID)
+				}
+				return db
+			},
+		},
+		{
+			name: "purge, close and reopen then create",
+			forget: func(t *testing.T, db Store, open func() Store, chirp Chirp) Store {
+				if err := db.DeleteChirp(chirp.ID, chirp.AuthorID); err != nil {
+					t.Fatal(err)
+				}
+				purgeAll(t, db)
+				if err := db.Close(); err != nil {
+					t.Fatal(err)
+				}
+				return open()
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			forEachStore(t, func(t *testing.T, open func() Store) {
+				db := open()
+				user := mustCreateUser(t, db, "author@example.com")
+				var last Chirp
+				for i := 0; i < 3; i++ {
+					last = mustCreateChirp(t, db, fmt.Sprintf("chirp %d", i), user.ID)
+				}
+
+				db = tt.forget(t, db, open, last)
+
+				next := mustCreateChirp(t, db, "next", user.ID)
+				if next.ID <= last.ID {
+					t.Fatalf("new chirp got ID %d, want more than %d", next.ID, last.ID)
+				}
+			})
+		})
+	}
+}
+
+func TestUserIDsNotReusedAfterReopen(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func() Store) {
+		db := open()
+		first := mustCreateUser(t, db, "first@example.com")
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		db = open()
+		second := mustCreateUser(t, db, "second@example.com")
+		if second.ID <= first.ID {
+			t.Fatalf("second user got ID %d, want more than %d", second.ID, first.ID)
+		}
+	})
+}
+
+// TestMigrateLegacyJSONSequences opens a file from before ID sequences
+// existed, in which DeleteChirp left zero-value chirps behind in place of
+// the deleted ones.
+func TestMigrateLegacyJSONSequences(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	legacy := `{
+  "chirps": {
+    "1": {"id": 1, "author_id": 1, "body": "first"},
+    "2": {"id": 0, "author_id": 0, "body": ""},
+    "3": {"id": 0, "author_id": 0, "body": ""}
+  },
+  "users": {
+    "1": {"id": 1, "email": "author@example.com", "hash": "aGFzaA=="},
+    "2": {"id": 2, "email": "other@example.com", "hash": "aGFzaA=="}
+  },
+  "tokens": {}
+}`
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := newJSONDB(Config{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.GetChirp(2); err == nil {
+		t.Error("zero-value chirp 2 survived the migration")
+	}
+	chirp := mustCreateChirp(t, db, "after upgrade", 1)
+	if chirp.ID != 4 {
+		t.Errorf("first chirp after upgrade got ID %d, want 4", chirp.ID)
+	}
+	user := mustCreateUser(t, db, "new@example.com")
+	if user.ID != 3 {
+		t.Errorf("first user after upgrade got ID %d, want 3", user.ID)
+	}
+}
+
+// TestMigrateLegacySQLiteSequences opens a database with the original
+// schema, whose newest chirp was deleted before the upgrade.
+func TestMigrateLegacySQLiteSequences(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Exec(sqliteMigrations[0] + `
+PRAGMA user_version = 1;
+INSERT INTO users (email, hash) VALUES ('author@example.com', x'00');
+INSERT INTO chirps (author_id, body) VALUES (1, 'first'), (1, 'second'), (1, 'third');
+DELETE FROM chirps WHERE id = 3;
+`)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewSQLiteDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	chirp := mustCreateChirp(t, db, "after upgrade", 1)
+	if chirp.ID != 4 {
+		t.Errorf("first chirp after upgrade got ID %d, want 4", chirp.ID)
+	}
+	user := mustCreateUser(t, db, "new@example.com")
+	if user.ID != 2 {
+		t.Errorf("first user after upgrade got ID %d, want 2", user.ID)
+	}
+}
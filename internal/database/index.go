@@ -0,0 +1,323 @@
+package database
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+)
+
+// chirpIndex keeps chirp IDs in order, overall, per author, per parent
+// chirp, per rechirped chirp, per hashtag and per mentioned user, so that
+// the JSON store can serve a page of chirps or a thread without scanning
+// the whole collection. It is rebuilt from the chirps on load and kept up
+// to date by the Tx methods that add, remove or edit chirps.
+type chirpIndex struct {
+	all      []int
+	byAuthor map[int][]int
+	replies  map[int][]int
+	rechirps map[int][]int
+	hashtags map[string][]int
+	mentions map[int][]int
+}
+
+func newChirpIndex(chirps map[int]Chirp) *chirpIndex {
+	idx := &chirpIndex{
+		all:      make([]int, 0, len(chirps)),
+		byAuthor: make(map[int][]int),
+		replies:  make(map[int][]int),
+		rechirps: make(map[int][]int),
+		hashtags: make(map[string][]int),
+		mentions: make(map[int][]int),
+	}
+	for _, chirp := range chirps {
+		idx.all = append(idx.all, chirp.ID)
+		idx.byAuthor[chirp.AuthorID] = append(idx.byAuthor[chirp.AuthorID], chirp.ID)
+		if chirp.ReplyTo != nil {
+			idx.replies[*chirp.ReplyTo] = append(idx.replies[*chirp.ReplyTo], chirp.ID)
+		}
+		if chirp.RechirpOf != nil {
+			idx.rechirps[*chirp.RechirpOf] = append(idx.rechirps[*chirp.RechirpOf], chirp.ID)
+		}
+		for _, tag := range chirp.Hashtags() {
+			idx.hashtags[tag] = append(idx.hashtags[tag], chirp.ID)
+		}
+		for _, userID := range chirp.Mentions() {
+			idx.mentions[userID] = append(idx.mentions[userID], chirp.ID)
+		}
+	}
+	sort.Ints(idx.all)
+	for _, ids := range idx.byAuthor {
+		sort.Ints(ids)
+	}
+	for _, ids := range idx.replies {
+		sort.Ints(ids)
+	}
+	for _, ids := range idx.rechirps {
+		sort.Ints(ids)
+	}
+	for _, ids := range idx.hashtags {
+		sort.Ints(ids)
+	}
+	for _, ids := range idx.mentions {
+		sort.Ints(ids)
+	}
+	return idx
+}
+
+func (idx *chirpIndex) add(chirp Chirp) {
+	idx.all = insertSorted(idx.all, chirp.ID)
+	idx.byAuthor[chirp.AuthorID] = insertSorted(idx.byAuthor[chirp.AuthorID], chirp.ID)
+	if chirp.ReplyTo != nil {
+		idx.replies[*chirp.ReplyTo] = insertSorted(idx.replies[*chirp.ReplyTo], chirp.ID)
+	}
+	if chirp.RechirpOf != nil {
+		idx.rechirps[*chirp.RechirpOf] = insertSorted(idx.rechirps[*chirp.RechirpOf], chirp.ID)
+	}
+	idx.addEntities(chirp)
+}
+
+func (idx *chirpIndex) remove(chirp Chirp) {
+	idx.all = removeSorted(idx.all, chirp.ID)
+	idx.byAuthor[chirp.AuthorID] = removeSorted(idx.byAuthor[chirp.AuthorID], chirp.ID)
+	if len(idx.byAuthor[chirp.AuthorID]) == 0 {
+		delete(idx.byAuthor, chirp.AuthorID)
+	}
+	if chirp.ReplyTo != nil {
+		idx.replies[*chirp.ReplyTo] = removeSorted(idx.replies[*chirp.ReplyTo], chirp.ID)
+		if len(idx.replies[*chirp.ReplyTo]) == 0 {
+			delete(idx.replies, *chirp.ReplyTo)
+		}
+	}
+	if chirp.RechirpOf != nil {
+		idx.rechirps[*chirp.RechirpOf] = removeSorted(idx.rechirps[*chirp.RechirpOf], chirp.ID)
+		if len(idx.rechirps[*chirp.RechirpOf]) == 0 {
+			delete(idx.rechirps, *chirp.RechirpOf)
+		}
+	}
+	idx.removeEntities(chirp)
+}
+
+// addEntities indexes the chirp's hashtags and mentions. Editing a chirp
+// changes them, so they can be reindexed on their own.
+func (idx *chirpIndex) addEntities(chirp Chirp) {
+	for _, tag := range chirp.Hashtags() {
+		idx.hashtags[tag] = insertSorted(idx.hashtags[tag], chirp.ID)
+	}
+	for _, userID := range chirp.Mentions() {
+		idx.mentions[userID] = insertSorted(idx.mentions[userID], chirp.ID)
+	}
+}
+
+func (idx *chirpIndex) removeEntities(chirp Chirp) {
+	for _, tag := range chirp.Hashtags() {
+		idx.hashtags[tag] = removeSorted(idx.hashtags[tag], chirp.ID)
+		if len(idx.hashtags[tag]) == 0 {
+			delete(idx.hashtags, tag)
+		}
+	}
+	for _, userID := range chirp.Mentions() {
+		idx.mentions[userID] = removeSorted(idx.mentions[userID], chirp.ID)
+		if len(idx.mentions[userID]) == 0 {
+			delete(idx.mentions, userID)
+		}
+	}
+}
+
+// insertSorted adds id to an ascending slice. New IDs are always the
+// largest, so this is an append in all but the rollback case.
+func insertSorted(ids []int, id int) []int {
+	i := sort.SearchInts(ids, id)
+	if i < len(ids) && ids[i] == id {
+		return ids
+	}
+	ids = append(ids, 0)
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	return ids
+}
+
+func removeSorted(ids []int, id int) []int {
+	i := sort.SearchInts(ids, id)
+	if i == len(ids) || ids[i] != id {
+		return ids
+	}
+	return append(ids[:i], ids[i+1:]...)
+}
+
+// likeIndex keeps the likes grouped by chirp, to count them, and by user,
+// ordered by when they were made.
+type likeIndex struct {
+	byChirp map[int][]int
+	byUser  map[int][]Like
+}
+
+// likeKey is the key of a like in DBStructure.Likes.
+func likeKey(chirpID int, userID int) string {
+	return fmt.Sprintf("%d:%d", chirpID, userID)
+}
+
+func newLikeIndex(likes map[string]Like) *likeIndex {
+	idx := &likeIndex{
+		byChirp: make(map[int][]int),
+		byUser:  make(map[int][]Like),
+	}
+	for _, like := range likes {
+		idx.add(like)
+	}
+	return idx
+}
+
+func (idx *likeIndex) add(like Like) {
+	idx.byChirp[like.ChirpID] = insertSorted(idx.byChirp[like.ChirpID], like.UserID)
+	idx.byUser[like.UserID] = insertOrdered(idx.byUser[like.UserID], like, likeBefore)
+}
+
+func (idx *likeIndex) remove(like Like) {
+	idx.byChirp[like.ChirpID] = removeSorted(idx.byChirp[like.ChirpID], like.UserID)
+	if len(idx.byChirp[like.ChirpID]) == 0 {
+		delete(idx.byChirp, like.ChirpID)
+	}
+	idx.byUser[like.UserID] = removeFirst(idx.byUser[like.UserID], func(l Like) bool {
+		return l.ChirpID == like.ChirpID
+	})
+	if len(idx.byUser[like.UserID]) == 0 {
+		delete(idx.byUser, like.UserID)
+	}
+}
+
+// likeBefore orders likes by creation time, then chirp ID.
+func likeBefore(a, b Like) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ChirpID < b.ChirpID
+}
+
+// followIndex keeps the follows grouped by follower and by followee, each
+// ordered by when they were made.
+type followIndex struct {
+	following map[int][]Follow
+	followers map[int][]Follow
+}
+
+// followKey is the key of a follow in DBStructure.Follows.
+func followKey(followerID int, followeeID int) string {
+	return fmt.Sprintf("%d:%d", followerID, followeeID)
+}
+
+func newFollowIndex(follows map[string]Follow) *followIndex {
+	idx := &followIndex{
+		following: make(map[int][]Follow),
+		followers: make(map[int][]Follow),
+	}
+	for _, follow := range follows {
+		idx.add(follow)
+	}
+	return idx
+}
+
+func (idx *followIndex) add(follow Follow) {
+	idx.following[follow.FollowerID] = insertOrdered(idx.following[follow.FollowerID], follow, followBefore)
+	idx.followers[follow.FolloweeID] = insertOrdered(idx.followers[follow.FolloweeID], follow, followBefore)
+}
+
+func (idx *followIndex) remove(follow Follow) {
+	same := func(f Follow) bool {
+		return f.FollowerID == follow.FollowerID && f.FolloweeID == follow.FolloweeID
+	}
+	idx.following[follow.FollowerID] = removeFirst(idx.following[follow.FollowerID], same)
+	if len(idx.following[follow.FollowerID]) == 0 {
+		delete(idx.following, follow.FollowerID)
+	}
+	idx.followers[follow.FolloweeID] = removeFirst(idx.followers[follow.FolloweeID], same)
+	if len(idx.followers[follow.FolloweeID]) == 0 {
+		delete(idx.followers, follow.FolloweeID)
+	}
+}
+
+// followBefore orders follows by creation time, then by the users involved.
+func followBefore(a, b Follow) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	if a.FollowerID != b.FollowerID {
+		return a.FollowerID < b.FollowerID
+	}
+	return a.FolloweeID < b.FolloweeID
+}
+
+// insertOrdered adds item to a slice kept in the order given by before.
+func insertOrdered[T any](items []T, item T, before func(a, b T) bool) []T {
+	i := sort.Search(len(items), func(i int) bool { return before(item, items[i]) })
+	var zero T
+	items = append(items, zero)
+	copy(items[i+1:], items[i:])
+	items[i] = item
+	return items
+}
+
+// removeFirst removes the first item that matches from a slice.
+func removeFirst[T any](items []T, match func(T) bool) []T {
+	for i := range items {
+		if match(items[i]) {
+			return append(items[:i], items[i+1:]...)
+		}
+	}
+	return items
+}
+
+// idHeap holds ascending lists of IDs, ordered by the ID each would yield
+// next: its first when merging upwards, its last when merging downwards.
+type idHeap struct {
+	lists [][]int
+	desc  bool
+}
+
+func (h *idHeap) next(i int) int {
+	if h.desc {
+		return h.lists[i][len(h.lists[i])-1]
+	}
+	return h.lists[i][0]
+}
+
+func (h *idHeap) Len() int { return len(h.lists) }
+func (h *idHeap) Less(i, j int) bool {
+	if h.desc {
+		return h.next(i) > h.next(j)
+	}
+	return h.next(i) < h.next(j)
+}
+func (h *idHeap) Swap(i, j int) { h.lists[i], h.lists[j] = h.lists[j], h.lists[i] }
+func (h *idHeap) Push(x any)    { h.lists = append(h.lists, x.([]int)) }
+func (h *idHeap) Pop() any {
+	last := h.lists[len(h.lists)-1]
+	h.lists = h.lists[:len(h.lists)-1]
+	return last
+}
+
+// mergeIDs walks the IDs of several ascending lists as one sequence, in
+// ascending or descending order, until fn returns false.
+func mergeIDs(lists [][]int, desc bool, fn func(id int) bool) {
+	h := &idHeap{desc: desc}
+	for _, ids := range lists {
+		if len(ids) > 0 {
+			h.lists = append(h.lists, ids)
+		}
+	}
+	heap.Init(h)
+	for h.Len() > 0 {
+		if !fn(h.next(0)) {
+			return
+		}
+		if desc {
+			h.lists[0] = h.lists[0][:len(h.lists[0])-1]
+		} else {
+			h.lists[0] = h.lists[0][1:]
+		}
+		if len(h.lists[0]) == 0 {
+			heap.Pop(h)
+		} else {
+			heap.Fix(h, 0)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// The JSON store keeps its data in two files: a snapshot (db.path) holding a
+// complete DBStructure, and a journal (db.path + ".wal") of the mutations
+// made since that snapshot was written. Each flush appends to the journal
+// and fsyncs it; once the journal grows past compactEvery entries, the
+// current state is written out as a new snapshot and the journal truncated.
+//
+// Journal entries are record-level puts and deletes keyed by collection and
+// record key, so replaying an entry twice is harmless. That makes the crash
+// between "snapshot renamed into place" and "journal truncated" safe.
+
+const defaultCompactEvery = 100
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+// walEntry is one line of the journal. Collection is the JSON name of a
+// DBStructure field, e.g. "chirps".
+type walEntry struct {
+	Op         string          `json:"op"`
+	Collection string          `json:"collection"`
+	Key        string          `json:"key"`
+	Value      json.RawMessage `json:"value,omitempty"`
+}
+
+// mutation describes a change to a single record, before it is encoded
+// into the journal. A nil value deletes the record.
+type mutation struct {
+	collection string
+	key        string
+	value      any
+}
+
+func put(collection string, key any, value any) mutation {
+	return mutation{collection: collection, key: fmt.Sprint(key), value: value}
+}
+
+func del(collection string, key any) mutation {
+	return mutation{collection: collection, key: fmt.Sprint(key)}
+}
+
+func (db *DB) journalPath() string {
+	return db.path + ".wal"
+}
+
+// encodeMutations turns mutations into journal entries. Values are encoded
+// straight away, while the caller still holds the lock that protects them.
+func encodeMutations(mutations []mutation) ([]walEntry, error) {
+	entries := make([]walEntry, 0, len(mutations))
+	for _, m := range mutations {
+		entry := walEntry{
+			Op:         opDelete,
+			Collection: m.collection,
+			Key:        m.key,
+		}
+		if m.value != nil {
+			value, err := json.Marshal(m.value)
+			if err != nil {
+				return nil, err
+			}
+			entry.Op = opPut
+			entry.Value = value
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// appendJournal writes the entries to the end of the journal and waits for
+// them to reach the disk. If that fails, the journal is cut back to where
+// it was, so that later entries never follow a torn one.
+func (db *DB) appendJournal(entries []walEntry) error {
+	buf := bytes.Buffer{}
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	f, err := os.OpenFile(db.journalPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Truncate(info.Size())
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readJournal returns every complete entry in the journal. A torn final
+// line, left behind by a crash in the middle of an append, is ignored.
+func (db *DB) readJournal() ([]walEntry, error) {
+	data, err := os.ReadFile(db.journalPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []walEntry{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		entry := walEntry{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			// Only the last line may be incomplete
+			if !bytes.HasSuffix(data, []byte("\n")) && bytes.HasSuffix(data, line) {
+				break
+			}
+			return nil, fmt.Errorf("corrupt journal entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// replayJournal applies the journal entries to a snapshot. It works on the
+// raw JSON so that new collections don't need any replay code of their own.
+func replayJournal(snapshot []byte, entries []walEntry) ([]byte, error) {
+	if len(entries) == 0 {
+		return snapshot, nil
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(snapshot, &fields); err != nil {
+		return nil, err
+	}
+	// Only the collections the journal touches are decoded
+	collections := map[string]map[string]json.RawMessage{}
+	for _, entry := range entries {
+		records, ok := collections[entry.Collection]
+		if !ok {
+			records = map[string]json.RawMessage{}
+			if raw, ok := fields[entry.Collection]; ok {
+				if err := json.Unmarshal(raw, &records); err != nil {
+					return nil, err
+				}
+				if records == nil {
+					records = map[string]json.RawMessage{}
+				}
+			}
+			collections[entry.Collection] = records
+		}
+		switch entry.Op {
+		case opPut:
+			records[entry.Key] = entry.Value
+		case opDelete:
+			delete(records, entry.Key)
+		default:
+			return nil, fmt.Errorf("unknown journal operation %q", entry.Op)
+		}
+	}
+	for name, records := range collections {
+		raw, err := json.Marshal(records)
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = raw
+	}
+	return json.Marshal(fields)
+}
+
+// truncateJournal drops every journal entry. It must only be called once a
+// snapshot containing those entries is safely on disk.
+func (db *DB) truncateJournal() error {
+	err := os.Remove(db.journalPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return syncDir(filepath.Dir(db.journalPath()))
+}
+
+// writeFileAtomic replaces path with data such that a reader (or a restart
+// after a crash) sees either the old or the new contents, never a mix.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	// Clean up the temp file on any failure before the rename
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes a preceding rename or remove in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mustOpenJSON opens the JSON store at path with compaction far enough off
+// that every write in a test stays in the journal.
+func mustOpenJSON(t *testing.T, path string) *DB {
+	t.Helper()
+	db, err := newJSONDB(Config{Path: path, CompactEvery: 1000})
+	if err != nil {
+		t.Fatalf("opening %s: %s", path, err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func readWAL(t *testing.T, path string) []byte {
+	t.Helper()
+	wal, err := os.ReadFile(path + ".wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wal
+}
+
+func TestReplayAfterCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := mustOpenJSON(t, path)
+	user := mustCreateUser(t, db, "user@example.com")
+	chirp, err := db.CreateChirp("hello", user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.UpdateUser(user.ID, "new@example.com", []byte("new hash")); err != nil {
+		t.Fatal(err)
+	}
+	// The writes are only in the journal; db is never closed, as if the
+	// process had died
+	wal := readWAL(t, path)
+	if len(wal) == 0 {
+		t.Fatal("journal is empty")
+	}
+
+	check := func(db *DB) {
+		t.Helper()
+		page, err := db.QueryChirps(ChirpQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Chirps) != 1 || page.Chirps[0].ID != chirp.ID || page.Chirps[0].Body != chirp.Body {
+			t.Fatalf("got chirps %+v, want only %+v", page.Chirps, chirp)
+		}
+		got, err := db.GetUserByEmail("new@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != user.ID || string(got.Hash) != "new hash" {
+			t.Errorf("got user %+v after replay", got)
+		}
+		if _, err := db.GetUserByEmail(user.Email); err == nil {
+			t.Error("old email still finds the user")
+		}
+	}
+	check(mustOpenJSON(t, path))
+
+	// Opening folded the journal into the snapshot. A crash before the
+	// journal was removed replays it a second time, which changes nothing.
+	if err := os.WriteFile(path+".wal", wal, 0644); err != nil {
+		t.Fatal(err)
+	}
+	check(mustOpenJSON(t, path))
+}
+
+func TestReplayDropsTornEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := mustOpenJSON(t, path)
+	for i := 0; i < 2; i++ {
+		mustCreateUser(t, db, fmt.Sprintf("user%d@example.com", i))
+	}
+	last := len(readWAL(t, path))
+	mustCreateUser(t, db, "user2@example.com")
+	wal := readWAL(t, path)
+
+	// A crash in the middle of the last append leaves part of it behind
+	torn := wal[:last+(len(wal)-last)/2]
+	if err := os.WriteFile(path+".wal", torn, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db = mustOpenJSON(t, path)
+	for i := 0; i < 2; i++ {
+		if _, err := db.GetUserByEmail(fmt.Sprintf("user%d@example.com", i)); err != nil {
+			t.Errorf("user %d lost: %s", i, err)
+		}
+	}
+	if _, err := db.GetUserByEmail("user2@example.com"); err == nil {
+		t.Error("torn entry was replayed")
+	}
+
+	// Writes carry on from the last complete entry
+	mustCreateUser(t, db, "user2@example.com")
+	db = mustOpenJSON(t, path)
+	if _, err := db.GetUserByEmail("user2@example.com"); err != nil {
+		t.Errorf("write after recovery lost: %s", err)
+	}
+}
+
+func TestReplayRejectsCorruptEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := mustOpenJSON(t, path)
+	for i := 0; i < 3; i++ {
+		mustCreateUser(t, db, fmt.Sprintf("user%d@example.com", i))
+	}
+	wal := readWAL(t, path)
+
+	// Only the last line can be torn by a crash; damage anywhere else
+	// must not be skipped over
+	first := bytes.IndexByte(wal, '\n')
+	corrupt := append(append([]byte{}, wal[:first/2]...), wal[first:]...)
+	if err := os.WriteFile(path+".wal", corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newJSONDB(Config{Path: path}); err == nil {
+		t.Fatal("opened a store with a corrupt journal")
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db")
+	if err := writeFileAtomic(path, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Errorf("got %q, want %q", data, "new")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("got mode %v, want 0644", info.Mode().Perm())
+	}
+	assertOnlyFiles(t, dir, "db")
+
+	// A failed rename leaves no temp file behind
+	target := filepath.Join(dir, "target")
+	if err := os.MkdirAll(filepath.Join(target, "in-use"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(target, []byte("new")); err == nil {
+		t.Fatal("replaced a directory")
+	}
+	assertOnlyFiles(t, dir, "db", "target")
+}
+
+func TestSyncDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := syncDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := syncDir(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("synced a directory that doesn't exist")
+	}
+}
+
+func assertOnlyFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, entry := range entries {
+		got = append(got, entry.Name())
+	}
+	if fmt.Sprint(got) != fmt.Sprint(names) {
+		t.Errorf("%s holds %v, want %v", dir, got, names)
+	}
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// LikeChirp records that the user likes a chirp. Liking a chirp twice is
+// the same as liking it once.
+func (db *DB) LikeChirp(chirpID int, userID int) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		chirp, ok = tx.Chirp(chirpID)
+		if !ok || !chirp.Visible() {
+			return errors.New("The chirp to be liked does not exist")
+		}
+		if _, ok := tx.Like(chirpID, userID); ok {
+			return nil
+		}
+		err := tx.PutLike(Like{
+			ChirpID:   chirpID,
+			UserID:    userID,
+			CreatedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+		chirp, _ = tx.Chirp(chirpID)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// UnlikeChirp removes the user's like from a chirp, if there is one.
+func (db *DB) UnlikeChirp(chirpID int, userID int) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		chirp, ok = tx.Chirp(chirpID)
+		if !ok || !chirp.Visible() {
+			return errors.New("The chirp to be unliked does not exist")
+		}
+		if err := tx.DeleteLike(chirpID, userID); err != nil {
+			return err
+		}
+		chirp, _ = tx.Chirp(chirpID)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// GetLikedChirps returns the chirps a user likes, most recently liked
+// first. Deleted chirps are left out.
+func (db *DB) GetLikedChirps(userID int) ([]Chirp, error) {
+	chirps := []Chirp{}
+	err := db.View(func(tx *Tx) error {
+		if _, ok := tx.User(userID); !ok {
+			return errors.New("User does not exist")
+		}
+		likes := tx.UserLikes(userID)
+		for i := len(likes) - 1; i >= 0; i-- {
+			chirp, ok := tx.Chirp(likes[i].ChirpID)
+			if !ok || !chirp.Visible() {
+				continue
+			}
+			chirps = append(chirps, chirp)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chirps, nil
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// jsonMigrations upgrade a DBStructure loaded from an older file. Migration
+// i brings a file from version i to i+1; like sqliteMigrations, entries are
+// append-only. The migrated state reaches the disk with the snapshot that
+// NewDB writes on start.
+var jsonMigrations = []func(dbStruct *DBStructure){
+	// Seed the ID sequences from the highest key in use, and drop the
+	// zero-value chirps that DeleteChirp used to leave behind to keep
+	// len(Chirps)+1 from reusing IDs.
+	func(dbStruct *DBStructure) {
+		for id, chirp := range dbStruct.Chirps {
+			if id > dbStruct.Sequences["chirps"] {
+				dbStruct.Sequences["chirps"] = id
+			}
+			if chirp.ID == 0 && chirp.Body == "" {
+				delete(dbStruct.Chirps, id)
+			}
+		}
+		for id := range dbStruct.Users {
+			if id > dbStruct.Sequences["users"] {
+				dbStruct.Sequences["users"] = id
+			}
+		}
+	},
+	// Backfill timestamps. The real creation times are lost, so records
+	// from before timestamps existed all share the time of the upgrade.
+	func(dbStruct *DBStructure) {
+		now := time.Now().UTC()
+		for id, chirp := range dbStruct.Chirps {
+			if chirp.CreatedAt.IsZero() {
+				chirp.CreatedAt = now
+				chirp.UpdatedAt = now
+				dbStruct.Chirps[id] = chirp
+			}
+		}
+		for id, user := range dbStruct.Users {
+			if user.CreatedAt.IsZero() {
+				user.CreatedAt = now
+				user.UpdatedAt = now
+				dbStruct.Users[id] = user
+			}
+		}
+	},
+	// Parse the hashtags and mentions of chirps from before entities
+	// existed. Mentions go to the users holding the handles now.
+	func(dbStruct *DBStructure) {
+		tx := &Tx{data: dbStruct}
+		for id, chirp := range dbStruct.Chirps {
+			if chirp.Entities == nil {
+				chirp.Entities = tx.entities(chirp.Body)
+				dbStruct.Chirps[id] = chirp
+			}
+		}
+	},
+	// Everyone from before roles existed is a plain user
+	func(dbStruct *DBStructure) {
+		for id, user := range dbStruct.Users {
+			if user.Role == "" {
+				user.Role = RoleUser
+				dbStruct.Users[id] = user
+			}
+		}
+	},
+	// The revoked tokens were whole refresh tokens from before sessions
+	// existed, which are no longer accepted anyway
+	func(dbStruct *DBStructure) {
+		dbStruct.RevokedTokens = make(map[string]RevokedToken)
+	},
+	// Revoked tokens from before expiries were recorded expire when a
+	// refresh token issued at the time of revocation would have
+	func(dbStruct *DBStructure) {
+		for id, revoked := range dbStruct.RevokedTokens {
+			if revoked.ExpiresAt.IsZero() {
+				revoked.ExpiresAt = revoked.RevokedAt.Add(60 * 24 * time.Hour)
+				dbStruct.RevokedTokens[id] = revoked
+			}
+		}
+	},
+}
+
+func migrateJSON(dbStruct *DBStructure) error {
+	if dbStruct.Version > len(jsonMigrations) {
+		return fmt.Errorf("database file version %d is newer than this binary supports (%d)", dbStruct.Version, len(jsonMigrations))
+	}
+	for _, migrate := range jsonMigrations[dbStruct.Version:] {
+		migrate(dbStruct)
+		dbStruct.Version++
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// checkBackfilledEntities checks that chirp 1, "hi @alice #Go" by user 1,
+// shows up in the hashtag and mention feeds and in trending.
+func checkBackfilledEntities(t *testing.T, db Store) {
+	t.Helper()
+	chirp, err := db.GetChirp(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirp.Entities) != 2 {
+		t.Fatalf("got entities %v, want a mention and a hashtag", chirp.Entities)
+	}
+	page, err := db.QueryChirps(ChirpQuery{Hashtag: "go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Chirps) != 1 {
+		t.Errorf("hashtag feed has %d chirps, want 1", len(page.Chirps))
+	}
+	page, err = db.QueryChirps(ChirpQuery{Mentioning: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Chirps) != 1 {
+		t.Errorf("mention feed has %d chirps, want 1", len(page.Chirps))
+	}
+	trending, err := db.TrendingHashtags(time.Time{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trending) != 1 || trending[0] != (HashtagCount{Tag: "go", Count: 1}) {
+		t.Errorf("got trending %v, want go once", trending)
+	}
+}
+
+func TestMigrateJSONBackfillsEntities(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	legacy := fmt.Sprintf(`{
+  "version": 2,
+  "chirps": {
+    "1": {"id": 1, "author_id": 1, "body": "hi @alice #Go", "created_at": %[1]q, "updated_at": %[1]q}
+  },
+  "users": {
+    "1": {"id": 1, "email": "alice@example.com", "hash": "aGFzaA==", "handle": "alice", "created_at": %[1]q, "updated_at": %[1]q}
+  },
+  "sequences": {"chirps": 1, "users": 1}
+}`, now)
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := newJSONDB(Config{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkBackfilledEntities(t, db)
+}
+
+func TestMigrateSQLiteBackfillsEntities(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range sqliteMigrations[:10] {
+		if _, err := conn.Exec(migration); err != nil {
+			conn.Close()
+			t.Fatal(err)
+		}
+	}
+	now := sqliteTime(time.Now())
+	_, err = conn.Exec(`
+PRAGMA user_version = 10;
+INSERT INTO users (email, hash, created_at, updated_at, handle) VALUES ('alice@example.com', x'00', ?1, ?1, 'alice');
+INSERT INTO chirps (author_id, body, created_at, updated_at) VALUES (1, 'hi @alice #Go', ?1, ?1);
+`, now)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewSQLiteDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkBackfilledEntities(t, db)
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ChirpQuery selects one page of the chirps that haven't been deleted.
+// Chirps are ordered by ID, which is also the order they were created in.
+type ChirpQuery struct {
+	// AuthorID limits the page to one author's chirps when non-zero
+	AuthorID int
+	// FollowedBy limits the page to chirps by the users this user follows
+	// when non-zero
+	FollowedBy int
+	// Hashtag limits the page to chirps using the hashtag, ignoring case,
+	// when set
+	Hashtag string
+	// Mentioning limits the page to chirps mentioning this user when
+	// non-zero
+	Mentioning int
+	// Since and Until, when set, limit the page to chirps created at or
+	// after Since and before Until
+	Since time.Time
+	Until time.Time
+	// Desc orders the chirps newest first instead of oldest first
+	Desc bool
+	// After continues a previous page; nil starts from the beginning
+	After *ChirpCursor
+	// Limit caps the number of chirps returned; zero means no limit
+	Limit int
+}
+
+// ChirpPage is the result of a ChirpQuery. Next is nil on the last page.
+type ChirpPage struct {
+	Chirps []Chirp
+	Next   *ChirpCursor
+}
+
+// ChirpCursor marks the last chirp of a page. Pages are keyed on the chirp
+// itself rather than on an offset, so chirps created or deleted while a
+// client is paging never shift it onto duplicates or past a gap.
+type ChirpCursor struct {
+	ID int `json:"id"`
+}
+
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// Encode renders the cursor as an opaque, URL-safe string.
+func (c ChirpCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeChirpCursor(s string) (ChirpCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return ChirpCursor{}, ErrInvalidCursor
+	}
+	cursor := ChirpCursor{}
+	err = json.Unmarshal(data, &cursor)
+	if err != nil || cursor.ID <= 0 {
+		return ChirpCursor{}, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+func cursorFor(chirp Chirp) *ChirpCursor {
+	return &ChirpCursor{ID: chirp.ID}
+}
+
+// ChirpThread is a chirp with the tree of replies below it, each level
+// ordered oldest first.
+type ChirpThread struct {
+	Chirp
+	Replies []ChirpThread
+}
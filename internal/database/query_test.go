@@ -0,0 +1,70 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// pageAll follows q's cursors to the end and returns the IDs it saw.
+func pageAll(t *testing.T, db Store, q ChirpQuery) []int {
+	t.Helper()
+	ids := []int{}
+	for {
+		page, err := db.QueryChirps(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, chirp := range page.Chirps {
+			ids = append(ids, chirp.ID)
+		}
+		if page.Next == nil {
+			return ids
+		}
+		cursor, err := DecodeChirpCursor(page.Next.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.After = &cursor
+	}
+}
+
+func TestQueryChirpsPages(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func() Store) {
+		db := open()
+		user := mustCreateUser(t, db, "author@example.com")
+		chirps := []Chirp{}
+		for i := 0; i < 5; i++ {
+			chirps = append(chirps, mustCreateChirp(t, db, fmt.Sprintf("chirp %d", i), user.ID))
+			// Keep the creation times apart for the time ranges below
+			time.Sleep(time.Millisecond)
+		}
+		if err := db.DeleteChirp(chirps[2].ID, user.ID); err != nil {
+			t.Fatal(err)
+		}
+		ids := func(indexes ...int) []int {
+			ids := []int{}
+			for _, i := range indexes {
+				ids = append(ids, chirps[i].ID)
+			}
+			return ids
+		}
+
+		tests := []struct {
+			name string
+			q    ChirpQuery
+			want []int
+		}{
+			{"oldest first", ChirpQuery{Limit: 2}, ids(0, 1, 3, 4)},
+			{"newest first", ChirpQuery{Limit: 2, Desc: true}, ids(4, 3, 1, 0)},
+			{"since", ChirpQuery{Limit: 1, Since: chirps[1].CreatedAt}, ids(1, 3, 4)},
+			{"until", ChirpQuery{Limit: 1, Desc: true, Until: chirps[4].CreatedAt}, ids(3, 1, 0)},
+		}
+		for _, tt := range tests {
+			got := pageAll(t, db, tt.q)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	})
+}
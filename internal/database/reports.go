@@ -0,0 +1,162 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// CreateReport files a report against a visible chirp. A reporter can have
+// only one open report per chirp.
+func (db *DB) CreateReport(chirpID int, reporterID int, reason string) (Report, error) {
+	report := Report{}
+	err := db.Update(func(tx *Tx) error {
+		chirp, ok := tx.Chirp(chirpID)
+		if !ok || !chirp.Visible() {
+			return errors.New("The chirp does not exist")
+		}
+		for _, other := range tx.Reports() {
+			if other.ChirpID == chirpID && other.ReporterID == reporterID && other.ResolvedAt == nil {
+				return ErrAlreadyReported
+			}
+		}
+		id, err := tx.NextID("reports")
+		if err != nil {
+			return err
+		}
+		report = Report{
+			ID:         id,
+			ChirpID:    chirpID,
+			ReporterID: reporterID,
+			Reason:     reason,
+			CreatedAt:  time.Now().UTC(),
+		}
+		return tx.PutReport(report)
+	})
+	if err != nil {
+		return Report{}, err
+	}
+	return report, nil
+}
+
+// GetReports returns the open reports, or the resolved ones, oldest first.
+func (db *DB) GetReports(resolved bool) ([]Report, error) {
+	reports := []Report{}
+	err := db.View(func(tx *Tx) error {
+		for _, report := range tx.Reports() {
+			if (report.ResolvedAt != nil) == resolved {
+				reports = append(reports, report)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+// ResolveReport takes a report out of the queue. Resolving it again
+// changes nothing.
+func (db *DB) ResolveReport(reportID int) (Report, error) {
+	report := Report{}
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		report, ok = tx.Report(reportID)
+		if !ok {
+			return errors.New("The report does not exist")
+		}
+		if report.ResolvedAt != nil {
+			return nil
+		}
+		resolvedAt := time.Now().UTC()
+		report.ResolvedAt = &resolvedAt
+		return tx.PutReport(report)
+	})
+	if err != nil {
+		return Report{}, err
+	}
+	return report, nil
+}
+
+// HideChirp keeps a chirp out of sight until UnhideChirp, along with its
+// plain rechirps, and resolves the open reports against it.
+func (db *DB) HideChirp(chirpID int) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		chirp, ok = tx.Chirp(chirpID)
+		if !ok || chirp.DeletedAt != nil {
+			return errors.New("The chirp does not exist")
+		}
+		if chirp.HiddenAt != nil {
+			return nil
+		}
+		now := time.Now().UTC()
+		chirp.HiddenAt = &now
+		if err := tx.PutChirp(chirp); err != nil {
+			return err
+		}
+		for _, id := range tx.RechirpIDs(chirpID) {
+			rechirp, _ := tx.Chirp(id)
+			if !rechirp.IsPlainRechirp() || !rechirp.Visible() {
+				continue
+			}
+			rechirp.HiddenAt = &now
+			if err := tx.PutChirp(rechirp); err != nil {
+				return err
+			}
+		}
+		for _, report := range tx.Reports() {
+			if report.ChirpID != chirpID || report.ResolvedAt != nil {
+				continue
+			}
+			report.ResolvedAt = &now
+			if err := tx.PutReport(report); err != nil {
+				return err
+			}
+		}
+		chirp, _ = tx.Chirp(chirpID)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// UnhideChirp shows a hidden chirp again, with the plain rechirps hidden
+// along with it.
+func (db *DB) UnhideChirp(chirpID int) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		chirp, ok = tx.Chirp(chirpID)
+		if !ok || chirp.DeletedAt != nil {
+			return errors.New("The chirp does not exist")
+		}
+		if chirp.HiddenAt == nil {
+			return nil
+		}
+		hiddenAt := *chirp.HiddenAt
+		chirp.HiddenAt = nil
+		if err := tx.PutChirp(chirp); err != nil {
+			return err
+		}
+		for _, id := range tx.RechirpIDs(chirpID) {
+			rechirp, _ := tx.Chirp(id)
+			if !rechirp.IsPlainRechirp() || rechirp.HiddenAt == nil || !rechirp.HiddenAt.Equal(hiddenAt) {
+				continue
+			}
+			rechirp.HiddenAt = nil
+			if err := tx.PutChirp(rechirp); err != nil {
+				return err
+			}
+		}
+		chirp, _ = tx.Chirp(chirpID)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
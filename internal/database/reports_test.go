@@ -0,0 +1,30 @@
+package database
+
+import "testing"
+
+func TestHiddenChirpCannotBeUpdated(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func() Store) {
+		db := open()
+		user := mustCreateUser(t, db, "author@example.com")
+		chirp := mustCreateChirp(t, db, "hello", user.ID)
+		if _, err := db.HideChirp(chirp.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := db.UpdateChirp(chirp.ID, user.ID, "edited"); err == nil {
+			t.Fatal("updated a hidden chirp")
+		}
+
+		// Once unhidden, the chirp is as it was and can be edited again
+		unhidden, err := db.UnhideChirp(chirp.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if unhidden.Body != "hello" {
+			t.Errorf("got body %q after the failed update, want %q", unhidden.Body, "hello")
+		}
+		if _, err := db.UpdateChirp(chirp.ID, user.ID, "edited"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
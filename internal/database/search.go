@@ -0,0 +1,279 @@
+package database
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var ErrInvalidSearch = errors.New("Search query has no words to look for")
+
+// SearchQuery describes a full-text search over the chirps that haven't
+// been deleted.
+//
+// Text is a list of words, all of which must appear in a chirp. Matching
+// ignores case and punctuation. A word ending in * matches any word it is
+// a prefix of, and words in double quotes must appear together as a
+// phrase; a * after the closing quote makes the phrase's last word a
+// prefix.
+type SearchQuery struct {
+	Text string
+	// ByRecency orders the results newest first instead of best match
+	// first
+	ByRecency bool
+	// Limit caps the number of chirps returned; zero means no limit
+	Limit int
+}
+
+// maxSearchClauses caps the number of words and phrases in a query.
+const maxSearchClauses = 16
+
+// searchClause is one word or phrase of a query, as a sequence of terms.
+// When prefix is set the last term matches any term it is a prefix of.
+type searchClause struct {
+	terms  []string
+	prefix bool
+}
+
+// tokenize splits text into lower-case terms, dropping everything that
+// isn't a letter or a digit. A term's position in text is its index.
+// It matches the unicode61 tokenizer SQLite's search index uses.
+func tokenize(text string) []string {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.Is(unicode.Co, r)
+	})
+	for i, field := range fields {
+		fields[i] = strings.ToLower(field)
+	}
+	return fields
+}
+
+func parseSearch(text string) ([]searchClause, error) {
+	clauses := []searchClause{}
+	for text != "" {
+		var phrase string
+		switch {
+		case text[0] == ' ' || text[0] == '\t' || text[0] == '\n':
+			text = text[1:]
+			continue
+		case text[0] == '"':
+			// An unterminated phrase runs to the end of the query
+			end := strings.IndexByte(text[1:], '"')
+			if end < 0 {
+				phrase, text = text[1:], ""
+			} else {
+				phrase, text = text[1:end+1], text[end+2:]
+			}
+		default:
+			end := strings.IndexAny(text, " \t\n\"")
+			if end < 0 {
+				end = len(text)
+			}
+			phrase, text = text[:end], text[end:]
+		}
+		prefix := strings.HasSuffix(phrase, "*")
+		if strings.HasPrefix(text, "*") {
+			prefix, text = true, text[1:]
+		}
+		terms := tokenize(phrase)
+		if len(terms) == 0 {
+			continue
+		}
+		clauses = append(clauses, searchClause{terms: terms, prefix: prefix})
+	}
+	if len(clauses) == 0 {
+		return nil, ErrInvalidSearch
+	}
+	if len(clauses) > maxSearchClauses {
+		clauses = clauses[:maxSearchClauses]
+	}
+	return clauses, nil
+}
+
+// searchIndex is the JSON store's inverted index of chirp bodies. Like
+// chirpIndex it lives only in memory: it is rebuilt on load and kept up to
+// date by the Tx methods that change chirps. Deleted chirps stay indexed
+// until they are purged, and are filtered out when searching.
+type searchIndex struct {
+	// postings maps each term to the chirps containing it and the
+	// positions it appears at
+	postings map[string]map[int][]int
+	// terms holds every term in postings, sorted, for prefix matching
+	terms []string
+	// lengths holds the number of terms in each chirp
+	lengths     map[int]int
+	totalLength int
+}
+
+func newSearchIndex(chirps map[int]Chirp) *searchIndex {
+	idx := &searchIndex{
+		postings: make(map[string]map[int][]int),
+		lengths:  make(map[int]int),
+	}
+	for _, chirp := range chirps {
+		idx.add(chirp)
+	}
+	return idx
+}
+
+func (idx *searchIndex) add(chirp Chirp) {
+	terms := tokenize(chirp.Body)
+	for pos, term := range terms {
+		chirps, ok := idx.postings[term]
+		if !ok {
+			chirps = make(map[int][]int)
+			idx.postings[term] = chirps
+			i := sort.SearchStrings(idx.terms, term)
+			idx.terms = append(idx.terms, "")
+			copy(idx.terms[i+1:], idx.terms[i:])
+			idx.terms[i] = term
+		}
+		chirps[chirp.ID] = append(chirps[chirp.ID], pos)
+	}
+	idx.lengths[chirp.ID] = len(terms)
+	idx.totalLength += len(terms)
+}
+
+func (idx *searchIndex) remove(chirp Chirp) {
+	for _, term := range tokenize(chirp.Body) {
+		chirps, ok := idx.postings[term]
+		if !ok {
+			continue
+		}
+		delete(chirps, chirp.ID)
+		if len(chirps) == 0 {
+			delete(idx.postings, term)
+			i := sort.SearchStrings(idx.terms, term)
+			if i < len(idx.terms) && idx.terms[i] == term {
+				idx.terms = append(idx.terms[:i], idx.terms[i+1:]...)
+			}
+		}
+	}
+	idx.totalLength -= idx.lengths[chirp.ID]
+	delete(idx.lengths, chirp.ID)
+}
+
+// expand returns the indexed terms a query term stands for.
+func (idx *searchIndex) expand(term string, prefix bool) []string {
+	if !prefix {
+		return []string{term}
+	}
+	terms := []string{}
+	for i := sort.SearchStrings(idx.terms, term); i < len(idx.terms) && strings.HasPrefix(idx.terms[i], term); i++ {
+		terms = append(terms, idx.terms[i])
+	}
+	return terms
+}
+
+// match returns how many times the clause occurs in each chirp containing
+// it.
+func (idx *searchIndex) match(clause searchClause) map[int]int {
+	// positions[i] holds, per chirp, the positions of the clause's i-th
+	// term
+	positions := make([]map[int]map[int]bool, len(clause.terms))
+	for i, term := range clause.terms {
+		positions[i] = make(map[int]map[int]bool)
+		prefix := clause.prefix && i == len(clause.terms)-1
+		for _, t := range idx.expand(term, prefix) {
+			for chirpID, at := range idx.postings[t] {
+				if positions[i][chirpID] == nil {
+					positions[i][chirpID] = make(map[int]bool)
+				}
+				for _, pos := range at {
+					positions[i][chirpID][pos] = true
+				}
+			}
+		}
+	}
+
+	counts := make(map[int]int)
+	for chirpID, starts := range positions[0] {
+		n := 0
+		for start := range starts {
+			found := true
+			for i := 1; i < len(positions) && found; i++ {
+				found = positions[i][chirpID][start+i]
+			}
+			if found {
+				n++
+			}
+		}
+		if n > 0 {
+			counts[chirpID] = n
+		}
+	}
+	return counts
+}
+
+// SearchChirps finds the chirps matching q.Text.
+func (db *DB) SearchChirps(q SearchQuery) ([]Chirp, error) {
+	clauses, err := parseSearch(q.Text)
+	if err != nil {
+		return nil, err
+	}
+	chirps := []Chirp{}
+	err = db.View(func(tx *Tx) error {
+		matches := make([]map[int]int, len(clauses))
+		for i, clause := range clauses {
+			matches[i] = tx.search.match(clause)
+		}
+		// Only chirps matching every clause count; start from the rarest
+		sort.Slice(matches, func(i, j int) bool { return len(matches[i]) < len(matches[j]) })
+		scores := map[int]float64{}
+		for chirpID := range matches[0] {
+			found := true
+			for _, counts := range matches[1:] {
+				if _, found = counts[chirpID]; !found {
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+			chirp, _ := tx.Chirp(chirpID)
+			if !chirp.Visible() {
+				continue
+			}
+			chirps = append(chirps, chirp)
+			scores[chirpID] = tx.search.score(chirpID, matches)
+		}
+		// IDs follow creation times, so the newest chirp has the highest ID
+		sort.Slice(chirps, func(i, j int) bool {
+			a, b := chirps[i], chirps[j]
+			if !q.ByRecency && scores[a.ID] != scores[b.ID] {
+				return scores[a.ID] > scores[b.ID]
+			}
+			return a.ID > b.ID
+		})
+		if q.Limit > 0 && len(chirps) > q.Limit {
+			chirps = chirps[:q.Limit]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chirps, nil
+}
+
+// score ranks a chirp with BM25, the ranking SQLite's search index uses.
+// matches holds, per clause, how often it occurs in each chirp.
+func (idx *searchIndex) score(chirpID int, matches []map[int]int) float64 {
+	const (
+		k1 = 1.2
+		b  = 0.75
+	)
+	n := float64(len(idx.lengths))
+	avgLength := float64(idx.totalLength) / n
+	length := float64(idx.lengths[chirpID])
+	score := 0.0
+	for _, counts := range matches {
+		df := float64(len(counts))
+		idf := math.Log((n-df+0.5)/(df+0.5) + 1)
+		tf := float64(counts[chirpID])
+		score += idf * tf * (k1 + 1) / (tf + k1*(1-b+b*length/avgLength))
+	}
+	return score
+}
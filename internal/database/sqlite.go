@@ -0,0 +1,302 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteDB is a Store backed by an embedded SQLite database.
+type SQLiteDB struct {
+	path string
+	conn *sql.DB
+}
+
+// sqliteMigrations holds the schema changes in the order they were made.
+// Migration i brings a database from user_version i to i+1; never edit an
+// entry once it has shipped, append a new one instead.
+var sqliteMigrations = []string{
+	`
+CREATE TABLE users (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	email         TEXT    NOT NULL UNIQUE,
+	hash          BLOB    NOT NULL,
+	is_chirpy_red INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE chirps (
+	id        INTEGER PRIMARY KEY AUTOINCREMENT,
+	author_id INTEGER NOT NULL,
+	body      TEXT    NOT NULL
+);
+CREATE INDEX chirps_author_id ON chirps (author_id);
+CREATE TABLE revoked_tokens (
+	id         TEXT     PRIMARY KEY,
+	revoked_at DATETIME NOT NULL
+);
+`,
+	`
+ALTER TABLE chirps ADD COLUMN deleted_at DATETIME;
+CREATE INDEX chirps_deleted_at ON chirps (deleted_at) WHERE deleted_at IS NOT NULL;
+`,
+	// Rows from before timestamps existed all get the time of the upgrade
+	`
+ALTER TABLE chirps ADD COLUMN created_at DATETIME NOT NULL DEFAULT '';
+ALTER TABLE chirps ADD COLUMN updated_at DATETIME NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN created_at DATETIME NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN updated_at DATETIME NOT NULL DEFAULT '';
+UPDATE chirps SET
+	created_at = strftime('%Y-%m-%dT%H:%M:%S.000000000Z', 'now'),
+	updated_at = strftime('%Y-%m-%dT%H:%M:%S.000000000Z', 'now');
+UPDATE users SET
+	created_at = strftime('%Y-%m-%dT%H:%M:%S.000000000Z', 'now'),
+	updated_at = strftime('%Y-%m-%dT%H:%M:%S.000000000Z', 'now');
+CREATE INDEX chirps_created_at ON chirps (created_at, id);
+`,
+	`
+CREATE TABLE chirp_revisions (
+	chirp_id    INTEGER  NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+	revision    INTEGER  NOT NULL,
+	body        TEXT     NOT NULL,
+	created_at  DATETIME NOT NULL,
+	replaced_at DATETIME NOT NULL,
+	PRIMARY KEY (chirp_id, revision)
+);
+`,
+	// reply_to is deliberately not a foreign key: purging a chirp leaves
+	// its replies pointing at a chirp that is gone, like the JSON store
+	`
+ALTER TABLE chirps ADD COLUMN reply_to INTEGER;
+CREATE INDEX chirps_reply_to ON chirps (reply_to);
+`,
+	`
+CREATE TABLE likes (
+	chirp_id   INTEGER  NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+	user_id    INTEGER  NOT NULL,
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY (chirp_id, user_id)
+);
+CREATE INDEX likes_user_id ON likes (user_id, created_at);
+`,
+	// Like reply_to, rechirp_of may outlive the chirp it points at. The
+	// unique index allows one live plain rechirp per user and chirp.
+	`
+ALTER TABLE chirps ADD COLUMN rechirp_of INTEGER;
+CREATE INDEX chirps_rechirp_of ON chirps (rechirp_of);
+CREATE UNIQUE INDEX chirps_plain_rechirp ON chirps (rechirp_of, author_id)
+	WHERE body = '' AND rechirp_of IS NOT NULL AND deleted_at IS NULL;
+`,
+	`
+CREATE TABLE follows (
+	follower_id INTEGER  NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	followee_id INTEGER  NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	created_at  DATETIME NOT NULL,
+	PRIMARY KEY (follower_id, followee_id)
+);
+CREATE INDEX follows_followee_id ON follows (followee_id, created_at);
+`,
+	`
+ALTER TABLE users ADD COLUMN handle TEXT;
+ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN bio TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN avatar_url TEXT NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX users_handle ON users (handle COLLATE NOCASE);
+`,
+	// The search index is kept in step with chirps by triggers
+	`
+CREATE VIRTUAL TABLE chirps_fts USING fts5 (
+	body,
+	content = 'chirps',
+	content_rowid = 'id',
+	tokenize = 'unicode61 remove_diacritics 0'
+);
+INSERT INTO chirps_fts (chirps_fts) VALUES ('rebuild');
+CREATE TRIGGER chirps_fts_insert AFTER INSERT ON chirps BEGIN
+	INSERT INTO chirps_fts (rowid, body) VALUES (new.id, new.body);
+END;
+CREATE TRIGGER chirps_fts_delete AFTER DELETE ON chirps BEGIN
+	INSERT INTO chirps_fts (chirps_fts, rowid, body) VALUES ('delete', old.id, old.body);
+END;
+CREATE TRIGGER chirps_fts_update AFTER UPDATE OF body ON chirps BEGIN
+	INSERT INTO chirps_fts (chirps_fts, rowid, body) VALUES ('delete', old.id, old.body);
+	INSERT INTO chirps_fts (rowid, body) VALUES (new.id, new.body);
+END;
+`,
+	// The entities column holds a chirp's hashtags and mentions as JSON;
+	// the tables below index them for the hashtag and mention feeds
+	`
+ALTER TABLE chirps ADD COLUMN entities TEXT;
+CREATE TABLE chirp_hashtags (
+	chirp_id INTEGER NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+	tag      TEXT    NOT NULL,
+	PRIMARY KEY (chirp_id, tag)
+);
+CREATE INDEX chirp_hashtags_tag ON chirp_hashtags (tag, chirp_id);
+CREATE TABLE chirp_mentions (
+	chirp_id INTEGER NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+	user_id  INTEGER NOT NULL,
+	PRIMARY KEY (chirp_id, user_id)
+);
+CREATE INDEX chirp_mentions_user_id ON chirp_mentions (user_id, chirp_id);
+`,
+	// Chirps from before entities existed get theirs in sqliteBackfills
+	``,
+	// A reporter can have one open report per chirp
+	`
+ALTER TABLE chirps ADD COLUMN hidden_at DATETIME;
+ALTER TABLE users ADD COLUMN suspended_at DATETIME;
+CREATE TABLE reports (
+	id          INTEGER  PRIMARY KEY AUTOINCREMENT,
+	chirp_id    INTEGER  NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+	reporter_id INTEGER  NOT NULL,
+	reason      TEXT     NOT NULL,
+	created_at  DATETIME NOT NULL,
+	resolved_at DATETIME
+);
+CREATE UNIQUE INDEX reports_open ON reports (chirp_id, reporter_id) WHERE resolved_at IS NULL;
+`,
+	`
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
+`,
+	// The revoked tokens were whole refresh tokens from before sessions
+	// existed, which are no longer accepted anyway
+	`
+CREATE TABLE sessions (
+	id           INTEGER  PRIMARY KEY AUTOINCREMENT,
+	user_id      INTEGER  NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	token_hash   TEXT     NOT NULL UNIQUE,
+	user_agent   TEXT     NOT NULL,
+	ip           TEXT     NOT NULL,
+	created_at   DATETIME NOT NULL,
+	last_used_at DATETIME NOT NULL,
+	expires_at   DATETIME NOT NULL
+);
+CREATE INDEX sessions_user_id ON sessions (user_id);
+DELETE FROM revoked_tokens;
+ALTER TABLE revoked_tokens ADD COLUMN session_id INTEGER;
+`,
+	// Revoked tokens from before expiries were recorded expire when a
+	// refresh token issued at the time of revocation would have
+	`
+ALTER TABLE revoked_tokens ADD COLUMN expires_at DATETIME NOT NULL DEFAULT '';
+UPDATE revoked_tokens SET expires_at = strftime('%Y-%m-%dT%H:%M:%S.000000000Z', revoked_at, '+60 days');
+CREATE INDEX revoked_tokens_expires_at ON revoked_tokens (expires_at);
+CREATE INDEX sessions_expires_at ON sessions (expires_at);
+`,
+	`
+ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0;
+`,
+}
+
+// sqliteBackfills run in the same transaction as the migration with the
+// same index, for changes that take more than SQL. Like the migrations,
+// they are never edited once shipped.
+var sqliteBackfills = map[int]func(tx *sql.Tx) error{
+	10: backfillEntities,
+}
+
+func NewSQLiteDB(path string) (*SQLiteDB, error) {
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer at a time; funnelling everything through
+	// one connection avoids SQLITE_BUSY errors under concurrent requests.
+	conn.SetMaxOpenConns(1)
+
+	pragmas := []string{
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA synchronous = NORMAL",
+		"PRAGMA foreign_keys = ON",
+	}
+	for _, pragma := range pragmas {
+		if _, err := conn.Exec(pragma); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	db := &SQLiteDB{
+		path: path,
+		conn: conn,
+	}
+	if err := db.migrate(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *SQLiteDB) migrate() error {
+	var version int
+	if err := db.conn.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("database schema version %d is newer than this binary supports (%d)", version, len(sqliteMigrations))
+	}
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := db.conn.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if backfill, ok := sqliteBackfills[i]; ok {
+			if err := backfill(tx); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d: %w", i+1, err)
+			}
+		}
+		// PRAGMA does not accept bound parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *SQLiteDB) ResetDB() error {
+	_, err := db.conn.Exec(`
+DELETE FROM sessions;
+DELETE FROM reports;
+DELETE FROM chirp_mentions;
+DELETE FROM chirp_hashtags;
+DELETE FROM follows;
+DELETE FROM likes;
+DELETE FROM chirp_revisions;
+DELETE FROM chirps;
+DELETE FROM users;
+DELETE FROM revoked_tokens;
+DELETE FROM sqlite_sequence;
+`)
+	return err
+}
+
+func (db *SQLiteDB) Close() error {
+	return db.conn.Close()
+}
+
+// sqliteTime formats t for storage in a DATETIME column. The fixed-width
+// layout keeps the text ordering of stored values the same as their time
+// ordering, so timestamps can be compared and sorted in SQL.
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000Z07:00")
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
@@ -0,0 +1,534 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+const chirpColumns = `chirps.id, chirps.author_id, chirps.body, chirps.created_at, chirps.updated_at,
+	chirps.deleted_at, chirps.hidden_at, chirps.reply_to, chirps.rechirp_of, chirps.entities,
+	(SELECT count(*) FROM chirps AS replies WHERE replies.reply_to = chirps.id AND replies.deleted_at IS NULL AND replies.hidden_at IS NULL),
+	(SELECT count(*) FROM likes WHERE likes.chirp_id = chirps.id),
+	coalesce((SELECT handle FROM users WHERE users.id = chirps.author_id), ''),
+	coalesce((SELECT display_name FROM users WHERE users.id = chirps.author_id), ''),
+	coalesce((SELECT avatar_url FROM users WHERE users.id = chirps.author_id), '')`
+
+func (db *SQLiteDB) CreateChirp(body string, userID int) (Chirp, error) {
+	return db.createChirp(Chirp{AuthorID: userID, Body: body})
+}
+
+func (db *SQLiteDB) CreateReply(body string, userID int, replyTo int) (Chirp, error) {
+	return db.createChirp(Chirp{AuthorID: userID, Body: body, ReplyTo: &replyTo})
+}
+
+func (db *SQLiteDB) CreateRechirp(body string, userID int, rechirpOf int) (Chirp, error) {
+	return db.createChirp(Chirp{AuthorID: userID, Body: body, RechirpOf: &rechirpOf})
+}
+
+func (db *SQLiteDB) createChirp(chirp Chirp) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	if chirp.ReplyTo != nil {
+		var exists bool
+		err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM chirps WHERE id = ? AND deleted_at IS NULL AND hidden_at IS NULL)`, *chirp.ReplyTo).Scan(&exists)
+		if err != nil {
+			return Chirp{}, err
+		}
+		if !exists {
+			return Chirp{}, ErrReplyParent
+		}
+	}
+	if chirp.RechirpOf != nil {
+		// Rechirping a plain rechirp reposts its original
+		var originalID int
+		err = tx.QueryRow(`
+SELECT CASE WHEN body = '' AND rechirp_of IS NOT NULL THEN rechirp_of ELSE id END
+FROM chirps WHERE id = ? AND deleted_at IS NULL AND hidden_at IS NULL`, *chirp.RechirpOf).Scan(&originalID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return Chirp{}, ErrRechirpOriginal
+		}
+		if err != nil {
+			return Chirp{}, err
+		}
+		chirp.RechirpOf = &originalID
+		if chirp.IsPlainRechirp() {
+			var exists bool
+			err = tx.QueryRow(`
+SELECT EXISTS (SELECT 1 FROM chirps
+WHERE rechirp_of = ? AND author_id = ? AND body = '' AND deleted_at IS NULL)`,
+				originalID, chirp.AuthorID).Scan(&exists)
+			if err != nil {
+				return Chirp{}, err
+			}
+			if exists {
+				return Chirp{}, ErrAlreadyRechirped
+			}
+		}
+	}
+
+	// Never go back in time, even if the clock does, so that creation
+	// times follow ID order like they do in the JSON store
+	now := sqliteTime(time.Now())
+	var last string
+	err = tx.QueryRow(`SELECT coalesce(max(created_at), '') FROM chirps`).Scan(&last)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if last > now {
+		now = last
+	}
+	chirp.Entities, err = sqliteEntities(tx, chirp.Body)
+	if err != nil {
+		return Chirp{}, err
+	}
+	entities, err := encodeEntities(chirp.Entities)
+	if err != nil {
+		return Chirp{}, err
+	}
+	row := tx.QueryRow(`
+INSERT INTO chirps (author_id, body, created_at, updated_at, reply_to, rechirp_of, entities)
+VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING `+chirpColumns,
+		chirp.AuthorID, chirp.Body, now, now, chirp.ReplyTo, chirp.RechirpOf, entities)
+	chirp, err = scanChirp(row)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if err := indexEntities(tx, chirp); err != nil {
+		return Chirp{}, err
+	}
+	chirps := []Chirp{chirp}
+	if err := attachOriginals(tx, chirps); err != nil {
+		return Chirp{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Chirp{}, err
+	}
+	return chirps[0], nil
+}
+
+func (db *SQLiteDB) QueryChirps(q ChirpQuery) (ChirpPage, error) {
+	query := `SELECT ` + chirpColumns + ` FROM chirps WHERE deleted_at IS NULL AND hidden_at IS NULL`
+	args := []any{}
+	if q.AuthorID != 0 {
+		query += ` AND author_id = ?`
+		args = append(args, q.AuthorID)
+	}
+	if q.FollowedBy != 0 {
+		query += ` AND author_id IN (SELECT followee_id FROM follows WHERE follower_id = ?)`
+		args = append(args, q.FollowedBy)
+	}
+	if q.Hashtag != "" {
+		query += ` AND id IN (SELECT chirp_id FROM chirp_hashtags WHERE tag = ?)`
+		args = append(args, hashtagKey(q.Hashtag))
+	}
+	if q.Mentioning != 0 {
+		query += ` AND id IN (SELECT chirp_id FROM chirp_mentions WHERE user_id = ?)`
+		args = append(args, q.Mentioning)
+	}
+	if !q.Since.IsZero() {
+		query += ` AND created_at >= ?`
+		args = append(args, sqliteTime(q.Since))
+	}
+	if !q.Until.IsZero() {
+		query += ` AND created_at < ?`
+		args = append(args, sqliteTime(q.Until))
+	}
+	if q.After != nil {
+		if q.Desc {
+			query += ` AND id < ?`
+		} else {
+			query += ` AND id > ?`
+		}
+		args = append(args, q.After.ID)
+	}
+	if q.Desc {
+		query += ` ORDER BY id DESC`
+	} else {
+		query += ` ORDER BY id`
+	}
+	if q.Limit > 0 {
+		// Fetch one extra row to learn whether there is a next page
+		query += ` LIMIT ?`
+		args = append(args, q.Limit+1)
+	}
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return ChirpPage{}, err
+	}
+	defer rows.Close()
+
+	page := ChirpPage{Chirps: []Chirp{}}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return ChirpPage{}, err
+		}
+		page.Chirps = append(page.Chirps, chirp)
+	}
+	if err := rows.Err(); err != nil {
+		return ChirpPage{}, err
+	}
+	if q.Limit > 0 && len(page.Chirps) > q.Limit {
+		page.Chirps = page.Chirps[:q.Limit]
+		page.Next = cursorFor(page.Chirps[q.Limit-1])
+	}
+	if err := attachOriginals(db.conn, page.Chirps); err != nil {
+		return ChirpPage{}, err
+	}
+	return page, nil
+}
+
+func (db *SQLiteDB) GetChirp(chirpID int) (Chirp, error) {
+	row := db.conn.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND deleted_at IS NULL AND hidden_at IS NULL`, chirpID)
+	chirp, err := scanChirp(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, errors.New("The chirp does not exist")
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirps := []Chirp{chirp}
+	if err := attachOriginals(db.conn, chirps); err != nil {
+		return Chirp{}, err
+	}
+	return chirps[0], nil
+}
+
+func (db *SQLiteDB) GetChirpThread(chirpID int, maxDepth int) (ChirpThread, error) {
+	rows, err := db.conn.Query(`
+WITH RECURSIVE thread (id, depth) AS (
+	SELECT id, 0 FROM chirps WHERE id = ? AND deleted_at IS NULL AND hidden_at IS NULL
+	UNION ALL
+	SELECT chirps.id, thread.depth + 1 FROM chirps JOIN thread ON chirps.reply_to = thread.id
+	WHERE chirps.deleted_at IS NULL AND chirps.hidden_at IS NULL AND thread.depth < ?
+)
+SELECT `+chirpColumns+` FROM chirps JOIN thread ON chirps.id = thread.id
+ORDER BY chirps.created_at, chirps.id`, chirpID, maxDepth)
+	if err != nil {
+		return ChirpThread{}, err
+	}
+	defer rows.Close()
+
+	// Rows come oldest first, so the root is first and every reply comes
+	// after its parent
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return ChirpThread{}, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	if err := rows.Err(); err != nil {
+		return ChirpThread{}, err
+	}
+	if len(chirps) == 0 {
+		return ChirpThread{}, errors.New("The chirp does not exist")
+	}
+	if err := attachOriginals(db.conn, chirps); err != nil {
+		return ChirpThread{}, err
+	}
+	nodes := map[int]*ChirpThread{}
+	for _, chirp := range chirps {
+		nodes[chirp.ID] = &ChirpThread{Chirp: chirp, Replies: []ChirpThread{}}
+	}
+	return assembleThread(chirps[0].ID, nodes), nil
+}
+
+// assembleThread links the flat set of thread nodes into a tree below
+// rootID.
+func assembleThread(rootID int, nodes map[int]*ChirpThread) ChirpThread {
+	children := map[int][]int{}
+	for id, node := range nodes {
+		if id != rootID && node.ReplyTo != nil {
+			children[*node.ReplyTo] = append(children[*node.ReplyTo], id)
+		}
+	}
+	var build func(id int) ChirpThread
+	build = func(id int) ChirpThread {
+		node := *nodes[id]
+		ids := children[id]
+		sort.Ints(ids)
+		for _, childID := range ids {
+			node.Replies = append(node.Replies, build(childID))
+		}
+		return node
+	}
+	return build(rootID)
+}
+
+func (db *SQLiteDB) UpdateChirp(chirpID int, userID int, body string) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND author_id = ? AND deleted_at IS NULL AND hidden_at IS NULL`,
+		chirpID, userID)
+	chirp, err := scanChirp(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, errors.New("The chirp to be updated does not exist")
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	if chirp.IsPlainRechirp() {
+		return Chirp{}, errors.New("A plain rechirp cannot be edited")
+	}
+	chirps := []Chirp{chirp}
+	if err := attachOriginals(tx, chirps); err != nil {
+		return Chirp{}, err
+	}
+	chirp = chirps[0]
+	if chirp.Body == body {
+		return chirp, nil
+	}
+
+	now := time.Now().UTC()
+	_, err = tx.Exec(`
+INSERT INTO chirp_revisions (chirp_id, revision, body, created_at, replaced_at)
+SELECT ?, coalesce(max(revision), 0) + 1, ?, ?, ? FROM chirp_revisions WHERE chirp_id = ?`,
+		chirp.ID, chirp.Body, sqliteTime(chirp.UpdatedAt), sqliteTime(now), chirp.ID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp.Body = body
+	chirp.UpdatedAt = now
+	chirp.Entities, err = sqliteEntities(tx, body)
+	if err != nil {
+		return Chirp{}, err
+	}
+	entities, err := encodeEntities(chirp.Entities)
+	if err != nil {
+		return Chirp{}, err
+	}
+	_, err = tx.Exec(`UPDATE chirps SET body = ?, updated_at = ?, entities = ? WHERE id = ?`,
+		body, sqliteTime(now), entities, chirp.ID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if err := indexEntities(tx, chirp); err != nil {
+		return Chirp{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (db *SQLiteDB) GetChirpRevisions(chirpID int) ([]ChirpRevision, error) {
+	if _, err := db.GetChirp(chirpID); err != nil {
+		return nil, err
+	}
+	rows, err := db.conn.Query(`
+SELECT chirp_id, revision, body, created_at, replaced_at FROM chirp_revisions
+WHERE chirp_id = ? ORDER BY revision`, chirpID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []ChirpRevision{}
+	for rows.Next() {
+		revision := ChirpRevision{}
+		err := rows.Scan(&revision.ChirpID, &revision.Revision, &revision.Body, &revision.CreatedAt, &revision.ReplacedAt)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+func (db *SQLiteDB) DeleteChirp(chirpID int, userId int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	deletedAt := sqliteTime(time.Now())
+	res, err := tx.Exec(`UPDATE chirps SET deleted_at = ? WHERE id = ? AND author_id = ? AND deleted_at IS NULL`,
+		deletedAt, chirpID, userId)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("The chirp to be deleted does not exist")
+	}
+	// Plain rechirps go with the original; quotes stay
+	_, err = tx.Exec(`UPDATE chirps SET deleted_at = ? WHERE rechirp_of = ? AND body = '' AND deleted_at IS NULL`,
+		deletedAt, chirpID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *SQLiteDB) RestoreChirp(chirpID int, userID int, deletedAfter time.Time) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND author_id = ? AND deleted_at IS NOT NULL`,
+		chirpID, userID)
+	chirp, err := scanChirp(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, errors.New("The chirp to be restored does not exist")
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	if chirp.DeletedAt.Before(deletedAfter) {
+		return Chirp{}, ErrRestoreExpired
+	}
+	if chirp.IsPlainRechirp() {
+		var exists bool
+		err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM chirps WHERE id = ? AND deleted_at IS NULL AND hidden_at IS NULL)`, *chirp.RechirpOf).Scan(&exists)
+		if err != nil {
+			return Chirp{}, err
+		}
+		if !exists {
+			return Chirp{}, ErrRechirpOriginal
+		}
+		err = tx.QueryRow(`
+SELECT EXISTS (SELECT 1 FROM chirps
+WHERE rechirp_of = ? AND author_id = ? AND body = '' AND deleted_at IS NULL)`,
+			*chirp.RechirpOf, userID).Scan(&exists)
+		if err != nil {
+			return Chirp{}, err
+		}
+		if exists {
+			return Chirp{}, ErrAlreadyRechirped
+		}
+	}
+	_, err = tx.Exec(`UPDATE chirps SET deleted_at = NULL WHERE id = ?`, chirpID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	// Bring back the plain rechirps that were deleted along with it
+	_, err = tx.Exec(`UPDATE chirps SET deleted_at = NULL WHERE rechirp_of = ? AND body = '' AND deleted_at = ?`,
+		chirpID, sqliteTime(*chirp.DeletedAt))
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp, err = scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, chirpID))
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirps := []Chirp{chirp}
+	if err := attachOriginals(tx, chirps); err != nil {
+		return Chirp{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Chirp{}, err
+	}
+	return chirps[0], nil
+}
+
+func (db *SQLiteDB) PurgeDeletedChirps(deletedBefore time.Time) (int, error) {
+	res, err := db.conn.Exec(`DELETE FROM chirps WHERE deleted_at IS NOT NULL AND deleted_at < ?`, sqliteTime(deletedBefore))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func scanChirp(row scanner) (Chirp, error) {
+	chirp := Chirp{}
+	deletedAt := sql.NullTime{}
+	hiddenAt := sql.NullTime{}
+	replyTo := sql.NullInt64{}
+	rechirpOf := sql.NullInt64{}
+	entities := sql.NullString{}
+	err := row.Scan(&chirp.ID, &chirp.AuthorID, &chirp.Body, &chirp.CreatedAt, &chirp.UpdatedAt,
+		&deletedAt, &hiddenAt, &replyTo, &rechirpOf, &entities, &chirp.ReplyCount, &chirp.LikeCount,
+		&chirp.Author.Handle, &chirp.Author.DisplayName, &chirp.Author.AvatarURL)
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp.Author.ID = chirp.AuthorID
+	if deletedAt.Valid {
+		chirp.DeletedAt = &deletedAt.Time
+	}
+	if hiddenAt.Valid {
+		chirp.HiddenAt = &hiddenAt.Time
+	}
+	if replyTo.Valid {
+		id := int(replyTo.Int64)
+		chirp.ReplyTo = &id
+	}
+	if rechirpOf.Valid {
+		id := int(rechirpOf.Int64)
+		chirp.RechirpOf = &id
+	}
+	if entities.Valid {
+		if err := json.Unmarshal([]byte(entities.String), &chirp.Entities); err != nil {
+			return Chirp{}, err
+		}
+	}
+	return chirp, nil
+}
+
+// attachOriginals fills in Original on the rechirps among chirps, leaving
+// it nil where the original has been deleted.
+func attachOriginals(q querier, chirps []Chirp) error {
+	query := ``
+	args := []any{}
+	for _, chirp := range chirps {
+		if chirp.RechirpOf == nil {
+			continue
+		}
+		if query != `` {
+			query += `, `
+		}
+		query += `?`
+		args = append(args, *chirp.RechirpOf)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	rows, err := q.Query(`SELECT `+chirpColumns+` FROM chirps WHERE id IN (`+query+`) AND deleted_at IS NULL AND hidden_at IS NULL`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	originals := map[int]Chirp{}
+	for rows.Next() {
+		original, err := scanChirp(rows)
+		if err != nil {
+			return err
+		}
+		originals[original.ID] = original
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i, chirp := range chirps {
+		if chirp.RechirpOf == nil {
+			continue
+		}
+		if original, ok := originals[*chirp.RechirpOf]; ok {
+			chirps[i].Original = &original
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// sqliteEntities parses the hashtags and mentions of a chirp body,
+// resolving the mentioned handles within tx.
+func sqliteEntities(tx *sql.Tx, body string) ([]Entity, error) {
+	return parseEntities(body, func(handle string) (int, error) {
+		var id int
+		err := tx.QueryRow(`SELECT id FROM users WHERE handle = ? COLLATE NOCASE`, handle).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return id, err
+	})
+}
+
+// encodeEntities renders entities for the entities column, which is NULL
+// for a chirp without any.
+func encodeEntities(entities []Entity) (any, error) {
+	if len(entities) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(entities)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// indexEntities replaces the rows that index the chirp's hashtags and
+// mentions.
+func indexEntities(tx *sql.Tx, chirp Chirp) error {
+	if _, err := tx.Exec(`DELETE FROM chirp_hashtags WHERE chirp_id = ?`, chirp.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM chirp_mentions WHERE chirp_id = ?`, chirp.ID); err != nil {
+		return err
+	}
+	for _, tag := range chirp.Hashtags() {
+		if _, err := tx.Exec(`INSERT INTO chirp_hashtags (chirp_id, tag) VALUES (?, ?)`, chirp.ID, tag); err != nil {
+			return err
+		}
+	}
+	for _, userID := range chirp.Mentions() {
+		if _, err := tx.Exec(`INSERT INTO chirp_mentions (chirp_id, user_id) VALUES (?, ?)`, chirp.ID, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillEntities parses the hashtags and mentions of the chirps written
+// before chirps had entities. Mentions are resolved to the users holding
+// the handles now, as the handles of the time are not known.
+func backfillEntities(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, body FROM chirps WHERE entities IS NULL`)
+	if err != nil {
+		return err
+	}
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp := Chirp{}
+		if err := rows.Scan(&chirp.ID, &chirp.Body); err != nil {
+			rows.Close()
+			return err
+		}
+		chirps = append(chirps, chirp)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, chirp := range chirps {
+		chirp.Entities, err = sqliteEntities(tx, chirp.Body)
+		if err != nil {
+			return err
+		}
+		if len(chirp.Entities) == 0 {
+			continue
+		}
+		entities, err := encodeEntities(chirp.Entities)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE chirps SET entities = ? WHERE id = ?`, entities, chirp.ID); err != nil {
+			return err
+		}
+		if err := indexEntities(tx, chirp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *SQLiteDB) TrendingHashtags(since time.Time, limit int) ([]HashtagCount, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := db.conn.Query(`
+SELECT chirp_hashtags.tag, count(*) FROM chirp_hashtags
+JOIN chirps ON chirps.id = chirp_hashtags.chirp_id
+WHERE chirps.created_at >= ? AND chirps.deleted_at IS NULL AND chirps.hidden_at IS NULL
+GROUP BY chirp_hashtags.tag
+ORDER BY count(*) DESC, chirp_hashtags.tag
+LIMIT ?`, sqliteTime(since), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trending := []HashtagCount{}
+	for rows.Next() {
+		count := HashtagCount{}
+		if err := rows.Scan(&count.Tag, &count.Count); err != nil {
+			return nil, err
+		}
+		trending = append(trending, count)
+	}
+	return trending, rows.Err()
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// FollowUser relies on the primary key of follows to make following
+// someone twice the same as following them once.
+func (db *SQLiteDB) FollowUser(followerID int, followeeID int) error {
+	if followerID == followeeID {
+		return ErrFollowSelf
+	}
+	res, err := db.conn.Exec(`INSERT OR IGNORE INTO follows (follower_id, followee_id, created_at)
+SELECT ?, id, ? FROM users WHERE id = ?`, followerID, sqliteTime(time.Now()), followeeID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return db.checkUserExists(followeeID)
+	}
+	return nil
+}
+
+func (db *SQLiteDB) UnfollowUser(followerID int, followeeID int) error {
+	if err := db.checkUserExists(followeeID); err != nil {
+		return err
+	}
+	_, err := db.conn.Exec(`DELETE FROM follows WHERE follower_id = ? AND followee_id = ?`, followerID, followeeID)
+	return err
+}
+
+func (db *SQLiteDB) GetFollowers(userID int) ([]Follow, error) {
+	return db.queryFollows(userID, `followee_id`)
+}
+
+func (db *SQLiteDB) GetFollowing(userID int) ([]Follow, error) {
+	return db.queryFollows(userID, `follower_id`)
+}
+
+// queryFollows lists the follows whose column matches userID, most recent
+// first.
+func (db *SQLiteDB) queryFollows(userID int, column string) ([]Follow, error) {
+	if err := db.checkUserExists(userID); err != nil {
+		return nil, err
+	}
+	rows, err := db.conn.Query(`SELECT follower_id, followee_id, created_at FROM follows WHERE `+column+` = ?
+ORDER BY created_at DESC, follower_id DESC, followee_id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	follows := []Follow{}
+	for rows.Next() {
+		follow := Follow{}
+		if err := rows.Scan(&follow.FollowerID, &follow.FolloweeID, &follow.CreatedAt); err != nil {
+			return nil, err
+		}
+		follows = append(follows, follow)
+	}
+	return follows, rows.Err()
+}
+
+func (db *SQLiteDB) checkUserExists(userID int) error {
+	var exists bool
+	err := db.conn.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE id = ?)`, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("User does not exist")
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LikeChirp relies on the primary key of likes to make liking a chirp
+// twice the same as liking it once.
+func (db *SQLiteDB) LikeChirp(chirpID int, userID int) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT OR IGNORE INTO likes (chirp_id, user_id, created_at)
+SELECT id, ?, ? FROM chirps WHERE id = ? AND deleted_at IS NULL AND hidden_at IS NULL`, userID, sqliteTime(time.Now()), chirpID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp, err := scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND deleted_at IS NULL AND hidden_at IS NULL`, chirpID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, errors.New("The chirp to be liked does not exist")
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirps := []Chirp{chirp}
+	if err := attachOriginals(tx, chirps); err != nil {
+		return Chirp{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Chirp{}, err
+	}
+	return chirps[0], nil
+}
+
+func (db *SQLiteDB) UnlikeChirp(chirpID int, userID int) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM likes WHERE chirp_id = ? AND user_id = ?`, chirpID, userID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp, err := scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND deleted_at IS NULL AND hidden_at IS NULL`, chirpID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, errors.New("The chirp to be unliked does not exist")
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirps := []Chirp{chirp}
+	if err := attachOriginals(tx, chirps); err != nil {
+		return Chirp{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Chirp{}, err
+	}
+	return chirps[0], nil
+}
+
+func (db *SQLiteDB) GetLikedChirps(userID int) ([]Chirp, error) {
+	if err := db.checkUserExists(userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query(`SELECT `+chirpColumns+` FROM chirps JOIN likes ON likes.chirp_id = chirps.id
+WHERE likes.user_id = ? AND chirps.deleted_at IS NULL AND chirps.hidden_at IS NULL
+ORDER BY likes.created_at DESC, likes.chirp_id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return chirps, attachOriginals(db.conn, chirps)
+}
@@ -0,0 +1,179 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const reportColumns = `id, chirp_id, reporter_id, reason, created_at, resolved_at`
+
+func (db *SQLiteDB) CreateReport(chirpID int, reporterID int, reason string) (Report, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Report{}, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM chirps WHERE id = ? AND deleted_at IS NULL AND hidden_at IS NULL)`,
+		chirpID).Scan(&exists)
+	if err != nil {
+		return Report{}, err
+	}
+	if !exists {
+		return Report{}, errors.New("The chirp does not exist")
+	}
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM reports WHERE chirp_id = ? AND reporter_id = ? AND resolved_at IS NULL)`,
+		chirpID, reporterID).Scan(&exists)
+	if err != nil {
+		return Report{}, err
+	}
+	if exists {
+		return Report{}, ErrAlreadyReported
+	}
+	row := tx.QueryRow(`
+INSERT INTO reports (chirp_id, reporter_id, reason, created_at) VALUES (?, ?, ?, ?) RETURNING `+reportColumns,
+		chirpID, reporterID, reason, sqliteTime(time.Now()))
+	report, err := scanReport(row)
+	if err != nil {
+		return Report{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Report{}, err
+	}
+	return report, nil
+}
+
+func (db *SQLiteDB) GetReports(resolved bool) ([]Report, error) {
+	rows, err := db.conn.Query(`SELECT `+reportColumns+` FROM reports WHERE (resolved_at IS NOT NULL) = ? ORDER BY id`, resolved)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+func (db *SQLiteDB) ResolveReport(reportID int) (Report, error) {
+	row := db.conn.QueryRow(`
+UPDATE reports SET resolved_at = coalesce(resolved_at, ?) WHERE id = ? RETURNING `+reportColumns,
+		sqliteTime(time.Now()), reportID)
+	report, err := scanReport(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Report{}, errors.New("The report does not exist")
+	}
+	return report, err
+}
+
+func (db *SQLiteDB) HideChirp(chirpID int) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	now := sqliteTime(time.Now())
+	res, err := tx.Exec(`UPDATE chirps SET hidden_at = ? WHERE id = ? AND deleted_at IS NULL AND hidden_at IS NULL`, now, chirpID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return Chirp{}, err
+	}
+	// Plain rechirps go with the original, and hiding the chirp settles
+	// the reports against it
+	if n > 0 {
+		_, err = tx.Exec(`
+UPDATE chirps SET hidden_at = ? WHERE rechirp_of = ? AND body = '' AND deleted_at IS NULL AND hidden_at IS NULL`,
+			now, chirpID)
+		if err != nil {
+			return Chirp{}, err
+		}
+		_, err = tx.Exec(`UPDATE reports SET resolved_at = ? WHERE chirp_id = ? AND resolved_at IS NULL`, now, chirpID)
+		if err != nil {
+			return Chirp{}, err
+		}
+	}
+	chirp, err := moderatedChirp(tx, chirpID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (db *SQLiteDB) UnhideChirp(chirpID int) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	var hiddenAt sql.NullString
+	err = tx.QueryRow(`SELECT hidden_at FROM chirps WHERE id = ? AND deleted_at IS NULL`, chirpID).Scan(&hiddenAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, errors.New("The chirp does not exist")
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	if hiddenAt.Valid {
+		_, err = tx.Exec(`UPDATE chirps SET hidden_at = NULL WHERE id = ?`, chirpID)
+		if err != nil {
+			return Chirp{}, err
+		}
+		// Bring back the plain rechirps that were hidden along with it
+		_, err = tx.Exec(`UPDATE chirps SET hidden_at = NULL WHERE rechirp_of = ? AND body = '' AND hidden_at = ?`,
+			chirpID, hiddenAt.String)
+		if err != nil {
+			return Chirp{}, err
+		}
+	}
+	chirp, err := moderatedChirp(tx, chirpID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// moderatedChirp reads back a chirp that isn't deleted, whether hidden or
+// not.
+func moderatedChirp(tx *sql.Tx, chirpID int) (Chirp, error) {
+	chirp, err := scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND deleted_at IS NULL`, chirpID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, errors.New("The chirp does not exist")
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirps := []Chirp{chirp}
+	if err := attachOriginals(tx, chirps); err != nil {
+		return Chirp{}, err
+	}
+	return chirps[0], nil
+}
+
+func scanReport(row scanner) (Report, error) {
+	report := Report{}
+	resolvedAt := sql.NullTime{}
+	err := row.Scan(&report.ID, &report.ChirpID, &report.ReporterID, &report.Reason, &report.CreatedAt, &resolvedAt)
+	if resolvedAt.Valid {
+		report.ResolvedAt = &resolvedAt.Time
+	}
+	return report, err
+}
@@ -0,0 +1,52 @@
+package database
+
+import "strings"
+
+func (db *SQLiteDB) SearchChirps(q SearchQuery) ([]Chirp, error) {
+	clauses, err := parseSearch(q.Text)
+	if err != nil {
+		return nil, err
+	}
+	order := `bm25(chirps_fts), chirps.id DESC`
+	if q.ByRecency {
+		order = `chirps.created_at DESC, chirps.id DESC`
+	}
+	limit := -1
+	if q.Limit > 0 {
+		limit = q.Limit
+	}
+	rows, err := db.conn.Query(`SELECT `+chirpColumns+` FROM chirps_fts JOIN chirps ON chirps.id = chirps_fts.rowid
+WHERE chirps_fts MATCH ? AND chirps.deleted_at IS NULL AND chirps.hidden_at IS NULL
+ORDER BY `+order+` LIMIT ?`, ftsQuery(clauses), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return chirps, attachOriginals(db.conn, chirps)
+}
+
+// ftsQuery renders clauses as an SQLite FTS5 query. Every term is quoted,
+// so nothing in the user's text is taken as query syntax.
+func ftsQuery(clauses []searchClause) string {
+	parts := make([]string, 0, len(clauses))
+	for _, clause := range clauses {
+		part := `"` + strings.Join(clause.terms, " ") + `"`
+		if clause.prefix {
+			part += "*"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " AND ")
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const sessionColumns = `id, user_id, token_hash, user_agent, ip, created_at, last_used_at, expires_at`
+
+func (db *SQLiteDB) CreateSession(session Session) (Session, error) {
+	now := sqliteTime(time.Now())
+	row := db.conn.QueryRow(`
+INSERT INTO sessions (user_id, token_hash, user_agent, ip, created_at, last_used_at, expires_at)
+VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING `+sessionColumns,
+		session.UserID, session.TokenHash, session.UserAgent, session.IP, now, now, sqliteTime(session.ExpiresAt))
+	return scanSession(row)
+}
+
+func (db *SQLiteDB) RotateSession(tokenHash string, next Session) (Session, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Session{}, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	session, err := scanSession(tx.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE token_hash = ?`, tokenHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		// A token rotated out earlier ends its session when replayed
+		var sessionID sql.NullInt64
+		err = tx.QueryRow(`SELECT session_id FROM revoked_tokens WHERE id = ?`, tokenHash).Scan(&sessionID)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !sessionID.Valid) {
+			return Session{}, ErrSessionNotFound
+		}
+		if err != nil {
+			return Session{}, err
+		}
+		_, err = tx.Exec(`DELETE FROM sessions WHERE id = ?`, sessionID.Int64)
+		if err != nil {
+			return Session{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return Session{}, err
+		}
+		return Session{}, ErrTokenReused
+	}
+	if err != nil {
+		return Session{}, err
+	}
+	if !session.ExpiresAt.After(now) {
+		return Session{}, ErrSessionNotFound
+	}
+
+	_, err = tx.Exec(`INSERT INTO revoked_tokens (id, revoked_at, expires_at, session_id) VALUES (?, ?, ?, ?)`,
+		tokenHash, sqliteTime(now), sqliteTime(session.ExpiresAt), session.ID)
+	if err != nil {
+		return Session{}, err
+	}
+	session, err = scanSession(tx.QueryRow(`
+UPDATE sessions SET token_hash = ?, user_agent = ?, ip = ?, last_used_at = ?, expires_at = ?
+WHERE id = ? RETURNING `+sessionColumns,
+		next.TokenHash, next.UserAgent, next.IP, sqliteTime(now), sqliteTime(next.ExpiresAt), session.ID))
+	if err != nil {
+		return Session{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Session{}, err
+	}
+	return session, nil
+}
+
+func (db *SQLiteDB) RevokeSession(tokenHash string) error {
+	res, err := db.conn.Exec(`DELETE FROM sessions WHERE token_hash = ?`, tokenHash)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func scanSession(row scanner) (Session, error) {
+	session := Session{}
+	err := row.Scan(&session.ID, &session.UserID, &session.TokenHash, &session.UserAgent, &session.IP,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt)
+	if err != nil {
+		return Session{}, err
+	}
+	return session, nil
+}
+
+func (db *SQLiteDB) GetSessions(userID int) ([]Session, error) {
+	rows, err := db.conn.Query(`
+SELECT `+sessionColumns+` FROM sessions WHERE user_id = ? AND expires_at > ?
+ORDER BY last_used_at DESC, id DESC`, userID, sqliteTime(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (db *SQLiteDB) DeleteSession(userID int, sessionID int) error {
+	res, err := db.conn.Exec(`DELETE FROM sessions WHERE id = ? AND user_id = ?`, sessionID, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (db *SQLiteDB) DeleteSessions(userID int) error {
+	_, err := db.conn.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID)
+	return err
+}
+
+func (db *SQLiteDB) PurgeExpiredTokens(now time.Time) (int, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	purged := 0
+	for _, table := range []string{"revoked_tokens", "sessions"} {
+		res, err := tx.Exec(`DELETE FROM `+table+` WHERE expires_at <= ?`, sqliteTime(now))
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		purged += int(n)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+func (db *SQLiteDB) GetTokenStats() (TokenStats, error) {
+	stats := TokenStats{}
+	err := db.conn.QueryRow(`SELECT (SELECT COUNT(*) FROM sessions), (SELECT COUNT(*) FROM revoked_tokens)`).
+		Scan(&stats.Sessions, &stats.RevokedTokens)
+	if err != nil {
+		return TokenStats{}, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const userColumns = `id, email, hash, is_chirpy_red, role, token_version, created_at, updated_at, suspended_at,
+	coalesce(handle, ''), display_name, bio, avatar_url`
+
+func (db *SQLiteDB) CreateUser(email string, hashedPassword []byte) (User, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	// Check if the user already exists
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE email = ?)`, email).Scan(&exists)
+	if err != nil {
+		return User{}, err
+	}
+	if exists {
+		return User{}, ErrAlreadyExists
+	}
+	now := sqliteTime(time.Now())
+	row := tx.QueryRow(`INSERT INTO users (email, hash, created_at, updated_at) VALUES (?, ?, ?, ?) RETURNING `+userColumns,
+		email, hashedPassword, now, now)
+	user, err := scanUser(row)
+	if err != nil {
+		return User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (db *SQLiteDB) GetUser(userID int) (User, error) {
+	row := db.conn.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, userID)
+	user, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errors.New("User does not exist")
+	}
+	return user, err
+}
+
+func (db *SQLiteDB) GetUserByHandle(handle string) (User, error) {
+	row := db.conn.QueryRow(`SELECT `+userColumns+` FROM users WHERE handle = ? COLLATE NOCASE`, handle)
+	user, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errors.New("Could not find user")
+	}
+	return user, err
+}
+
+func (db *SQLiteDB) GetUserByEmail(useremail string) (User, error) {
+	row := db.conn.QueryRow(`SELECT `+userColumns+` FROM users WHERE email = ?`, useremail)
+	user, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errors.New("Could not find user")
+	}
+	return user, err
+}
+
+func (db *SQLiteDB) UpdateUser(userIDInt int, email string, hashedPassword []byte) (User, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	// The new email can't be another user's
+	var taken bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE email = ? AND id != ?)`, email, userIDInt).Scan(&taken)
+	if err != nil {
+		return User{}, err
+	}
+	if taken {
+		return User{}, ErrAlreadyExists
+	}
+	row := tx.QueryRow(`UPDATE users SET email = ?, hash = ?, token_version = token_version + 1, updated_at = ? WHERE id = ? RETURNING `+userColumns,
+		email, hashedPassword, sqliteTime(time.Now()), userIDInt)
+	user, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errors.New("User does not exist")
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, userIDInt); err != nil {
+		return User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (db *SQLiteDB) UpgradeUserStatus(userIDInt int) (User, error) {
+	row := db.conn.QueryRow(`UPDATE users SET is_chirpy_red = 1, updated_at = ? WHERE id = ? RETURNING `+userColumns,
+		sqliteTime(time.Now()), userIDInt)
+	user, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errors.New("User does not exist")
+	}
+	return user, err
+}
+
+func (db *SQLiteDB) UpdateProfile(userID int, profile Profile) (User, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	if profile.Handle != "" {
+		var taken bool
+		err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE handle = ? COLLATE NOCASE AND id != ?)`,
+			profile.Handle, userID).Scan(&taken)
+		if err != nil {
+			return User{}, err
+		}
+		if taken {
+			return User{}, ErrHandleTaken
+		}
+	}
+	row := tx.QueryRow(`
+UPDATE users SET handle = nullif(?, ''), display_name = ?, bio = ?, avatar_url = ?, updated_at = ?
+WHERE id = ? RETURNING `+userColumns,
+		profile.Handle, profile.DisplayName, profile.Bio, profile.AvatarURL, sqliteTime(time.Now()), userID)
+	user, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errors.New("User does not exist")
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (db *SQLiteDB) SuspendUser(userID int) (User, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	// Suspending a suspended user leaves them as they are
+	now := sqliteTime(time.Now())
+	row := tx.QueryRow(`
+UPDATE users SET
+	suspended_at = coalesce(suspended_at, ?),
+	token_version = CASE WHEN suspended_at IS NULL THEN token_version + 1 ELSE token_version END,
+	updated_at = CASE WHEN suspended_at IS NULL THEN ? ELSE updated_at END
+WHERE id = ? RETURNING `+userColumns,
+		now, now, userID)
+	user, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errors.New("User does not exist")
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID); err != nil {
+		return User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (db *SQLiteDB) UnsuspendUser(userID int) (User, error) {
+	row := db.conn.QueryRow(`
+UPDATE users SET
+	suspended_at = NULL,
+	updated_at = CASE WHEN suspended_at IS NULL THEN updated_at ELSE ? END
+WHERE id = ? RETURNING `+userColumns,
+		sqliteTime(time.Now()), userID)
+	user, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errors.New("User does not exist")
+	}
+	return user, err
+}
+
+func (db *SQLiteDB) SetUserRole(userID int, role string) (User, error) {
+	row := db.conn.QueryRow(`
+UPDATE users SET
+	role = ?,
+	updated_at = CASE WHEN role = ? THEN updated_at ELSE ? END
+WHERE id = ? RETURNING `+userColumns,
+		role, role, sqliteTime(time.Now()), userID)
+	user, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errors.New("User does not exist")
+	}
+	return user, err
+}
+
+func scanUser(row scanner) (User, error) {
+	user := User{}
+	suspendedAt := sql.NullTime{}
+	err := row.Scan(&user.ID, &user.Email, &user.Hash, &user.IsChirpyRed, &user.Role, &user.TokenVersion, &user.CreatedAt, &user.UpdatedAt, &suspendedAt,
+		&user.Handle, &user.DisplayName, &user.Bio, &user.AvatarURL)
+	if suspendedAt.Valid {
+		user.SuspendedAt = &suspendedAt.Time
+	}
+	return user, err
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Store is the set of operations the API handlers need from a storage
+// backend. DB (a single JSON file) and SQLiteDB both implement it.
+type Store interface {
+	CreateChirp(body string, userID int) (Chirp, error)
+	CreateReply(body string, userID int, replyTo int) (Chirp, error)
+	CreateRechirp(body string, userID int, rechirpOf int) (Chirp, error)
+	GetChirpThread(chirpID int, maxDepth int) (ChirpThread, error)
+	QueryChirps(q ChirpQuery) (ChirpPage, error)
+	SearchChirps(q SearchQuery) ([]Chirp, error)
+	TrendingHashtags(since time.Time, limit int) ([]HashtagCount, error)
+	GetChirp(chirpID int) (Chirp, error)
+	UpdateChirp(chirpID int, userID int, body string) (Chirp, error)
+	GetChirpRevisions(chirpID int) ([]ChirpRevision, error)
+	DeleteChirp(chirpID int, userID int) error
+	RestoreChirp(chirpID int, userID int, deletedAfter time.Time) (Chirp, error)
+	PurgeDeletedChirps(deletedBefore time.Time) (int, error)
+
+	LikeChirp(chirpID int, userID int) (Chirp, error)
+	UnlikeChirp(chirpID int, userID int) (Chirp, error)
+	GetLikedChirps(userID int) ([]Chirp, error)
+
+	CreateUser(email string, hashedPassword []byte) (User, error)
+	GetUser(userID int) (User, error)
+	GetUserByEmail(email string) (User, error)
+	GetUserByHandle(handle string) (User, error)
+	UpdateUser(userID int, email string, hashedPassword []byte) (User, error)
+	UpgradeUserStatus(userID int) (User, error)
+	UpdateProfile(userID int, profile Profile) (User, error)
+
+	SetUserRole(userID int, role string) (User, error)
+	SuspendUser(userID int) (User, error)
+	UnsuspendUser(userID int) (User, error)
+
+	CreateReport(chirpID int, reporterID int, reason string) (Report, error)
+	GetReports(resolved bool) ([]Report, error)
+	ResolveReport(reportID int) (Report, error)
+	HideChirp(chirpID int) (Chirp, error)
+	UnhideChirp(chirpID int) (Chirp, error)
+
+	FollowUser(followerID int, followeeID int) error
+	UnfollowUser(followerID int, followeeID int) error
+	GetFollowers(userID int) ([]Follow, error)
+	GetFollowing(userID int) ([]Follow, error)
+
+	CreateSession(session Session) (Session, error)
+	RotateSession(tokenHash string, next Session) (Session, error)
+	RevokeSession(tokenHash string) error
+	GetSessions(userID int) ([]Session, error)
+	DeleteSession(userID int, sessionID int) error
+	DeleteSessions(userID int) error
+	PurgeExpiredTokens(now time.Time) (int, error)
+	GetTokenStats() (TokenStats, error)
+
+	ResetDB() error
+	Close() error
+}
+
+const (
+	DriverJSON   = "json"
+	DriverSQLite = "sqlite"
+)
+
+// Config selects and configures a storage backend.
+type Config struct {
+	Driver string
+	Path   string
+
+	// CompactEvery is the number of journal entries after which the JSON
+	// store rewrites its snapshot. Zero selects a default.
+	CompactEvery int
+	// FlushInterval is how often the JSON store writes committed changes
+	// to disk. Zero writes them before each update returns.
+	FlushInterval time.Duration
+}
+
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*SQLiteDB)(nil)
+)
+
+// Open returns the Store selected by cfg.Driver. An empty driver selects the
+// JSON file store.
+func Open(cfg Config) (Store, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("database path not set")
+	}
+	switch cfg.Driver {
+	case "", DriverJSON:
+		return newJSONDB(cfg)
+	case DriverSQLite:
+		return NewSQLiteDB(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", cfg.Driver)
+	}
+}
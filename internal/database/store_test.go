@@ -0,0 +1,46 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// forEachStore runs test once against a fresh JSON store and once against
+// a fresh SQLite store. Every call to open opens the same database again;
+// the stores it returns are closed when the test ends.
+func forEachStore(t *testing.T, test func(t *testing.T, open func() Store)) {
+	for _, driver := range []string{DriverJSON, DriverSQLite} {
+		driver := driver
+		t.Run(driver, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db")
+			open := func() Store {
+				t.Helper()
+				db, err := Open(Config{Driver: driver, Path: path})
+				if err != nil {
+					t.Fatalf("opening %s store: %s", driver, err)
+				}
+				t.Cleanup(func() { db.Close() })
+				return db
+			}
+			test(t, open)
+		})
+	}
+}
+
+func mustCreateUser(t *testing.T, db Store, email string) User {
+	t.Helper()
+	user, err := db.CreateUser(email, []byte("hash"))
+	if err != nil {
+		t.Fatalf("CreateUser(%q): %s", email, err)
+	}
+	return user
+}
+
+func mustCreateChirp(t *testing.T, db Store, body string, userID int) Chirp {
+	t.Helper()
+	chirp, err := db.CreateChirp(body, userID)
+	if err != nil {
+		t.Fatalf("CreateChirp(%q): %s", body, err)
+	}
+	return chirp
+}
@@ -2,41 +2,178 @@ package database
 
 import (
 	"errors"
+	"sort"
 	"time"
 )
 
-func (db *DB) RevokeToken(tokenToRevoke string) error {
-	// Load the current database
-	dbStruct, err := db.loadDB()
+// CreateSession stores a new session. ID, CreatedAt and LastUsedAt are set
+// here.
+func (db *DB) CreateSession(session Session) (Session, error) {
+	err := db.Update(func(tx *Tx) error {
+		if _, ok := tx.User(session.UserID); !ok {
+			return errors.New("User does not exist")
+		}
+		id, err := tx.NextID("sessions")
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		session.ID = id
+		session.CreatedAt = now
+		session.LastUsedAt = now
+		return tx.PutSession(session)
+	})
 	if err != nil {
-		return err
+		return Session{}, err
 	}
-	alreadyRevoked, err := db.IsTokenRevoked(tokenToRevoke)
-	if alreadyRevoked {
-		return errors.New("Token already revoked")
+	return session, nil
+}
+
+// RotateSession swaps the refresh token of the session holding tokenHash
+// for next.TokenHash, taking the client details and expiry from next. The
+// old token is revoked; presenting it again ends the session, since one of
+// the two parties using it is not who they claim to be, and returns
+// ErrTokenReused.
+func (db *DB) RotateSession(tokenHash string, next Session) (Session, error) {
+	session := Session{}
+	reused := false
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		session, ok = tx.SessionByToken(tokenHash)
+		if !ok {
+			revoked, ok := tx.RevokedToken(tokenHash)
+			if !ok || revoked.SessionID == 0 {
+				return ErrSessionNotFound
+			}
+			reused = true
+			return tx.DeleteSession(revoked.SessionID)
+		}
+		now := time.Now().UTC()
+		if !session.ExpiresAt.After(now) {
+			return ErrSessionNotFound
+		}
+		// The old token expires when the session would have
+		err := tx.PutRevokedToken(RevokedToken{
+			ID:        tokenHash,
+			RevokedAt: now,
+			ExpiresAt: session.ExpiresAt,
+			SessionID: session.ID,
+		})
+		if err != nil {
+			return err
+		}
+		session.TokenHash = next.TokenHash
+		session.UserAgent = next.UserAgent
+		session.IP = next.IP
+		session.LastUsedAt = now
+		session.ExpiresAt = next.ExpiresAt
+		return tx.PutSession(session)
+	})
+	if err != nil {
+		return Session{}, err
 	}
-	// Create a new revoked token and write to db
-	revoked := RevokedToken{
-		ID:        tokenToRevoke,
-		RevokedAt: time.Now().UTC(),
+	if reused {
+		return Session{}, ErrTokenReused
 	}
-	dbStruct.RevokedTokens[tokenToRevoke] = revoked
-	err = db.writeDB(dbStruct)
+	return session, nil
+}
+
+// RevokeSession ends the session whose current refresh token has the given
+// hash.
+func (db *DB) RevokeSession(tokenHash string) error {
+	return db.Update(func(tx *Tx) error {
+		session, ok := tx.SessionByToken(tokenHash)
+		if !ok {
+			return ErrSessionNotFound
+		}
+		return tx.DeleteSession(session.ID)
+	})
+}
+
+// GetSessions returns a user's sessions that haven't expired, most recently
+// used first.
+func (db *DB) GetSessions(userID int) ([]Session, error) {
+	sessions := []Session{}
+	err := db.View(func(tx *Tx) error {
+		now := time.Now().UTC()
+		for _, session := range tx.data.Sessions {
+			if session.UserID == userID && session.ExpiresAt.After(now) {
+				sessions = append(sessions, session)
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastUsedAt.Equal(sessions[j].LastUsedAt) {
+			return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+		}
+		return sessions[i].ID > sessions[j].ID
+	})
+	return sessions, nil
 }
 
-func (db *DB) IsTokenRevoked(tokenToCheck string) (bool, error) {
-	// Load the current database
-	dbStruct, err := db.loadDB()
+// DeleteSession ends one of a user's sessions.
+func (db *DB) DeleteSession(userID int, sessionID int) error {
+	return db.Update(func(tx *Tx) error {
+		session, ok := tx.Session(sessionID)
+		if !ok || session.UserID != userID {
+			return ErrSessionNotFound
+		}
+		return tx.DeleteSession(sessionID)
+	})
+}
+
+// DeleteSessions ends every session of a user.
+func (db *DB) DeleteSessions(userID int) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.DeleteUserSessions(userID)
+	})
+}
+
+// PurgeExpiredTokens removes the sessions and revoked tokens that expired
+// before now, returning how many records it removed. Expired tokens fail
+// validation anyway, so nothing is lost.
+func (db *DB) PurgeExpiredTokens(now time.Time) (int, error) {
+	purged := 0
+	err := db.Update(func(tx *Tx) error {
+		for id, revoked := range tx.data.RevokedTokens {
+			if revoked.ExpiresAt.After(now) {
+				continue
+			}
+			if err := tx.DeleteRevokedToken(id); err != nil {
+				return err
+			}
+			purged++
+		}
+		for id, session := range tx.data.Sessions {
+			if session.ExpiresAt.After(now) {
+				continue
+			}
+			if err := tx.DeleteSession(id); err != nil {
+				return err
+			}
+			purged++
+		}
+		return nil
+	})
 	if err != nil {
-		return false, err
+		return 0, err
 	}
-	_, ok := dbStruct.RevokedTokens[tokenToCheck]
-	if ok {
-		return true, nil
+	return purged, nil
+}
+
+func (db *DB) GetTokenStats() (TokenStats, error) {
+	stats := TokenStats{}
+	err := db.View(func(tx *Tx) error {
+		stats.Sessions = len(tx.data.Sessions)
+		stats.RevokedTokens = len(tx.data.RevokedTokens)
+		return nil
+	})
+	if err != nil {
+		return TokenStats{}, err
 	}
-	return false, nil
+	return stats, nil
 }
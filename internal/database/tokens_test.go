@@ -0,0 +1,42 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRotateSession(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func() Store) {
+		db := open()
+		user := mustCreateUser(t, db, "user@example.com")
+		expires := time.Now().Add(time.Hour)
+		created, err := db.CreateSession(Session{UserID: user.ID, TokenHash: "first", ExpiresAt: expires})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rotated, err := db.RotateSession("first", Session{TokenHash: "second", ExpiresAt: expires})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rotated.ID != created.ID || rotated.TokenHash != "second" {
+			t.Fatalf("got session %+v after rotating %+v", rotated, created)
+		}
+
+		// The token that was rotated out gives the session away
+		_, err = db.RotateSession("first", Session{TokenHash: "third", ExpiresAt: expires})
+		if !errors.Is(err, ErrTokenReused) {
+			t.Fatalf("got error %v, want ErrTokenReused", err)
+		}
+		_, err = db.RotateSession("second", Session{TokenHash: "third", ExpiresAt: expires})
+		if !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("session survived a reused token: got error %v, want ErrSessionNotFound", err)
+		}
+
+		_, err = db.RotateSession("unknown", Session{TokenHash: "fourth", ExpiresAt: expires})
+		if !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("unknown token: got error %v, want ErrSessionNotFound", err)
+		}
+	})
+}
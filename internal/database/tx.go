@@ -0,0 +1,509 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+var ErrTxReadOnly = errors.New("Transaction is read-only")
+
+// Tx is a consistent view of the database for the duration of a View or
+// Update call. Changes made through a Tx are kept only if the Update
+// function returns nil; otherwise they are rolled back.
+type Tx struct {
+	data      *DBStructure
+	chirps    *chirpIndex
+	likes     *likeIndex
+	follows   *followIndex
+	search    *searchIndex
+	writable  bool
+	mutations []mutation
+	undo      []func()
+}
+
+// View runs fn with a read-only transaction. Any number of View calls may
+// run at once, but never alongside an Update.
+func (db *DB) View(fn func(tx *Tx) error) error {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	return fn(db.newTx(false))
+}
+
+// Update runs fn with a writable transaction. Updates are applied one after
+// another, so concurrent updates never overwrite each other. The changes are
+// queued for the background flusher, or, when the store has no flush
+// interval, written out before Update returns; if that write fails they are
+// rolled back, so a failed Update never leaves anything behind.
+func (db *DB) Update(fn func(tx *Tx) error) error {
+	writeNow := db.flushInterval == 0
+	if writeNow {
+		// Taken before mux, in the same order as flush
+		db.flushMux.Lock()
+		defer db.flushMux.Unlock()
+	}
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	tx := db.newTx(true)
+	err := fn(tx)
+	if err != nil {
+		tx.rollback()
+		return err
+	}
+	entries, err := encodeMutations(tx.mutations)
+	if err != nil {
+		tx.rollback()
+		return err
+	}
+	if !writeNow {
+		db.pending = append(db.pending, entries...)
+		return nil
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	// Readers wait for the write, so that none of them sees changes that
+	// may yet be rolled back
+	err = db.writeEntries(entries)
+	if err != nil {
+		tx.rollback()
+		return err
+	}
+	return nil
+}
+
+func (db *DB) newTx(writable bool) *Tx {
+	return &Tx{
+		data:     &db.data,
+		chirps:   db.chirps,
+		likes:    db.likes,
+		follows:  db.follows,
+		search:   db.search,
+		writable: writable,
+	}
+}
+
+func (tx *Tx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+	tx.mutations = nil
+	tx.undo = nil
+}
+
+// putRecord stores value under key in one of the DBStructure collections,
+// remembering how to undo the change and what to write to the journal.
+func putRecord[K comparable, V any](tx *Tx, collection string, records map[K]V, key K, value V) error {
+	if !tx.writable {
+		return ErrTxReadOnly
+	}
+	old, existed := records[key]
+	tx.undo = append(tx.undo, func() {
+		if existed {
+			records[key] = old
+		} else {
+			delete(records, key)
+		}
+	})
+	tx.mutations = append(tx.mutations, put(collection, key, value))
+	records[key] = value
+	return nil
+}
+
+// deleteRecord is the removal counterpart of putRecord.
+func deleteRecord[K comparable, V any](tx *Tx, collection string, records map[K]V, key K) error {
+	if !tx.writable {
+		return ErrTxReadOnly
+	}
+	old, existed := records[key]
+	if !existed {
+		return nil
+	}
+	tx.undo = append(tx.undo, func() {
+		records[key] = old
+	})
+	tx.mutations = append(tx.mutations, del(collection, key))
+	delete(records, key)
+	return nil
+}
+
+// NextID allocates the next ID in a collection. IDs are never reused, even
+// after the record holding one is deleted.
+func (tx *Tx) NextID(collection string) (int, error) {
+	id := tx.data.Sequences[collection] + 1
+	err := putRecord(tx, "sequences", tx.data.Sequences, collection, id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// Chirp returns the chirp with the given ID, with its author, its reply
+// and like counts and the chirp it rechirps filled in.
+func (tx *Tx) Chirp(chirpID int) (Chirp, bool) {
+	chirp, ok := tx.chirp(chirpID)
+	if !ok {
+		return Chirp{}, false
+	}
+	if chirp.RechirpOf != nil {
+		original, ok := tx.chirp(*chirp.RechirpOf)
+		if ok && original.Visible() {
+			chirp.Original = &original
+		}
+	}
+	return chirp, true
+}
+
+// chirp is Chirp without the rechirped chirp.
+func (tx *Tx) chirp(chirpID int) (Chirp, bool) {
+	chirp, ok := tx.data.Chirps[chirpID]
+	if !ok {
+		return Chirp{}, false
+	}
+	chirp.ReplyCount = 0
+	for _, replyID := range tx.chirps.replies[chirpID] {
+		if tx.data.Chirps[replyID].Visible() {
+			chirp.ReplyCount++
+		}
+	}
+	chirp.LikeCount = len(tx.likes.byChirp[chirpID])
+	chirp.Original = nil
+	author := tx.data.Users[chirp.AuthorID]
+	chirp.Author = Author{
+		ID:          chirp.AuthorID,
+		Handle:      author.Handle,
+		DisplayName: author.DisplayName,
+		AvatarURL:   author.AvatarURL,
+	}
+	return chirp, true
+}
+
+// Chirps returns every chirp, deleted ones included, sorted by ID.
+func (tx *Tx) Chirps() []Chirp {
+	chirps := make([]Chirp, 0, len(tx.chirps.all))
+	for _, id := range tx.chirps.all {
+		chirp, _ := tx.Chirp(id)
+		chirps = append(chirps, chirp)
+	}
+	return chirps
+}
+
+// ReplyIDs returns the IDs of the replies to a chirp, deleted ones
+// included, in ascending order. The slice must not be modified.
+func (tx *Tx) ReplyIDs(chirpID int) []int {
+	return tx.chirps.replies[chirpID]
+}
+
+// RechirpIDs returns the IDs of the rechirps and quotes of a chirp,
+// deleted ones included, in ascending order. The slice must not be
+// modified.
+func (tx *Tx) RechirpIDs(chirpID int) []int {
+	return tx.chirps.rechirps[chirpID]
+}
+
+// HashtagChirpIDs returns the IDs of the chirps using a hashtag, deleted
+// ones included, in ascending order. The slice must not be modified.
+func (tx *Tx) HashtagChirpIDs(tag string) []int {
+	return tx.chirps.hashtags[hashtagKey(tag)]
+}
+
+// MentionChirpIDs returns the IDs of the chirps mentioning a user, deleted
+// ones included, in ascending order. The slice must not be modified.
+func (tx *Tx) MentionChirpIDs(userID int) []int {
+	return tx.chirps.mentions[userID]
+}
+
+// ChirpIDs returns the IDs of every chirp, or of one author's chirps when
+// authorID is non-zero, in ascending order. The slice must not be modified.
+func (tx *Tx) ChirpIDs(authorID int) []int {
+	if authorID != 0 {
+		return tx.chirps.byAuthor[authorID]
+	}
+	return tx.chirps.all
+}
+
+func (tx *Tx) PutChirp(chirp Chirp) error {
+	old, existed := tx.data.Chirps[chirp.ID]
+	err := putRecord(tx, "chirps", tx.data.Chirps, chirp.ID, chirp)
+	if err != nil {
+		return err
+	}
+	if !existed {
+		tx.chirps.add(chirp)
+		tx.search.add(chirp)
+		tx.undo = append(tx.undo, func() {
+			tx.chirps.remove(chirp)
+			tx.search.remove(chirp)
+		})
+	} else if old.Body != chirp.Body {
+		tx.chirps.removeEntities(old)
+		tx.chirps.addEntities(chirp)
+		tx.search.remove(old)
+		tx.search.add(chirp)
+		tx.undo = append(tx.undo, func() {
+			tx.chirps.removeEntities(chirp)
+			tx.chirps.addEntities(old)
+			tx.search.remove(chirp)
+			tx.search.add(old)
+		})
+	}
+	return nil
+}
+
+func (tx *Tx) DeleteChirp(chirpID int) error {
+	chirp, existed := tx.data.Chirps[chirpID]
+	err := deleteRecord(tx, "chirps", tx.data.Chirps, chirpID)
+	if err != nil {
+		return err
+	}
+	if existed {
+		tx.chirps.remove(chirp)
+		tx.search.remove(chirp)
+		tx.undo = append(tx.undo, func() {
+			tx.chirps.add(chirp)
+			tx.search.add(chirp)
+		})
+	}
+	return nil
+}
+
+// Revisions returns a chirp's earlier bodies, oldest first. The slice must
+// not be modified.
+func (tx *Tx) Revisions(chirpID int) []ChirpRevision {
+	return tx.data.Revisions[chirpID]
+}
+
+// AddRevision appends a revision to its chirp's history.
+func (tx *Tx) AddRevision(revision ChirpRevision) error {
+	old := tx.data.Revisions[revision.ChirpID]
+	// Copy rather than append in place, so that rolling back restores the
+	// old slice untouched
+	revisions := make([]ChirpRevision, 0, len(old)+1)
+	revisions = append(revisions, old...)
+	revisions = append(revisions, revision)
+	return putRecord(tx, "revisions", tx.data.Revisions, revision.ChirpID, revisions)
+}
+
+func (tx *Tx) DeleteRevisions(chirpID int) error {
+	return deleteRecord(tx, "revisions", tx.data.Revisions, chirpID)
+}
+
+// Like returns the user's like of a chirp.
+func (tx *Tx) Like(chirpID int, userID int) (Like, bool) {
+	like, ok := tx.data.Likes[likeKey(chirpID, userID)]
+	return like, ok
+}
+
+// ChirpLikes returns the IDs of the users who like a chirp, in ascending
+// order. The slice must not be modified.
+func (tx *Tx) ChirpLikes(chirpID int) []int {
+	return tx.likes.byChirp[chirpID]
+}
+
+// UserLikes returns a user's likes, oldest first. The slice must not be
+// modified.
+func (tx *Tx) UserLikes(userID int) []Like {
+	return tx.likes.byUser[userID]
+}
+
+func (tx *Tx) PutLike(like Like) error {
+	key := likeKey(like.ChirpID, like.UserID)
+	old, existed := tx.data.Likes[key]
+	err := putRecord(tx, "likes", tx.data.Likes, key, like)
+	if err != nil {
+		return err
+	}
+	if existed {
+		tx.likes.remove(old)
+	}
+	tx.likes.add(like)
+	tx.undo = append(tx.undo, func() {
+		tx.likes.remove(like)
+		if existed {
+			tx.likes.add(old)
+		}
+	})
+	return nil
+}
+
+func (tx *Tx) DeleteLike(chirpID int, userID int) error {
+	key := likeKey(chirpID, userID)
+	like, existed := tx.data.Likes[key]
+	err := deleteRecord(tx, "likes", tx.data.Likes, key)
+	if err != nil {
+		return err
+	}
+	if existed {
+		tx.likes.remove(like)
+		tx.undo = append(tx.undo, func() { tx.likes.add(like) })
+	}
+	return nil
+}
+
+// Follow returns the record of one user following another.
+func (tx *Tx) Follow(followerID int, followeeID int) (Follow, bool) {
+	follow, ok := tx.data.Follows[followKey(followerID, followeeID)]
+	return follow, ok
+}
+
+// Following returns the follows made by a user, oldest first. The slice
+// must not be modified.
+func (tx *Tx) Following(userID int) []Follow {
+	return tx.follows.following[userID]
+}
+
+// Followers returns the follows of a user, oldest first. The slice must
+// not be modified.
+func (tx *Tx) Followers(userID int) []Follow {
+	return tx.follows.followers[userID]
+}
+
+func (tx *Tx) PutFollow(follow Follow) error {
+	key := followKey(follow.FollowerID, follow.FolloweeID)
+	old, existed := tx.data.Follows[key]
+	err := putRecord(tx, "follows", tx.data.Follows, key, follow)
+	if err != nil {
+		return err
+	}
+	if existed {
+		tx.follows.remove(old)
+	}
+	tx.follows.add(follow)
+	tx.undo = append(tx.undo, func() {
+		tx.follows.remove(follow)
+		if existed {
+			tx.follows.add(old)
+		}
+	})
+	return nil
+}
+
+func (tx *Tx) DeleteFollow(followerID int, followeeID int) error {
+	key := followKey(followerID, followeeID)
+	follow, existed := tx.data.Follows[key]
+	err := deleteRecord(tx, "follows", tx.data.Follows, key)
+	if err != nil {
+		return err
+	}
+	if existed {
+		tx.follows.remove(follow)
+		tx.undo = append(tx.undo, func() { tx.follows.add(follow) })
+	}
+	return nil
+}
+
+// User returns the user with the given ID.
+func (tx *Tx) User(userID int) (User, bool) {
+	user, ok := tx.data.Users[userID]
+	return user, ok
+}
+
+// UserByEmail returns the user registered with the given email.
+func (tx *Tx) UserByEmail(email string) (User, bool) {
+	for _, user := range tx.data.Users {
+		if user.Email == email {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// UserByHandle returns the user with the given handle, ignoring case.
+func (tx *Tx) UserByHandle(handle string) (User, bool) {
+	for _, user := range tx.data.Users {
+		if user.Handle != "" && strings.EqualFold(user.Handle, handle) {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// entities parses the hashtags and mentions of a chirp body.
+func (tx *Tx) entities(body string) []Entity {
+	entities, _ := parseEntities(body, func(handle string) (int, error) {
+		user, _ := tx.UserByHandle(handle)
+		return user.ID, nil
+	})
+	return entities
+}
+
+func (tx *Tx) PutUser(user User) error {
+	return putRecord(tx, "users", tx.data.Users, user.ID, user)
+}
+
+// Report returns the report with the given ID.
+func (tx *Tx) Report(reportID int) (Report, bool) {
+	report, ok := tx.data.Reports[reportID]
+	return report, ok
+}
+
+// Reports returns every report, resolved ones included, sorted by ID.
+func (tx *Tx) Reports() []Report {
+	reports := make([]Report, 0, len(tx.data.Reports))
+	for _, report := range tx.data.Reports {
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].ID < reports[j].ID })
+	return reports
+}
+
+func (tx *Tx) PutReport(report Report) error {
+	return putRecord(tx, "reports", tx.data.Reports, report.ID, report)
+}
+
+func (tx *Tx) DeleteReport(reportID int) error {
+	return deleteRecord(tx, "reports", tx.data.Reports, reportID)
+}
+
+// RevokedToken returns the revocation record for a token hash.
+func (tx *Tx) RevokedToken(tokenHash string) (RevokedToken, bool) {
+	revoked, ok := tx.data.RevokedTokens[tokenHash]
+	return revoked, ok
+}
+
+func (tx *Tx) PutRevokedToken(revoked RevokedToken) error {
+	return putRecord(tx, "tokens", tx.data.RevokedTokens, revoked.ID, revoked)
+}
+
+func (tx *Tx) DeleteRevokedToken(tokenHash string) error {
+	return deleteRecord(tx, "tokens", tx.data.RevokedTokens, tokenHash)
+}
+
+func (tx *Tx) Session(sessionID int) (Session, bool) {
+	session, ok := tx.data.Sessions[sessionID]
+	return session, ok
+}
+
+// SessionByToken returns the session whose current refresh token has the
+// given hash.
+func (tx *Tx) SessionByToken(tokenHash string) (Session, bool) {
+	for _, session := range tx.data.Sessions {
+		if session.TokenHash == tokenHash {
+			return session, true
+		}
+	}
+	return Session{}, false
+}
+
+func (tx *Tx) PutSession(session Session) error {
+	return putRecord(tx, "sessions", tx.data.Sessions, session.ID, session)
+}
+
+func (tx *Tx) DeleteSession(sessionID int) error {
+	return deleteRecord(tx, "sessions", tx.data.Sessions, sessionID)
+}
+
+// DeleteUserSessions ends every session of a user.
+func (tx *Tx) DeleteUserSessions(userID int) error {
+	for _, session := range tx.data.Sessions {
+		if session.UserID != userID {
+			continue
+		}
+		if err := tx.DeleteSession(session.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
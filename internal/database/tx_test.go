@@ -0,0 +1,76 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateRollsBackFailedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db, err := newJSONDB(Config{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	user := mustCreateUser(t, db, "author@example.com")
+	chirp := mustCreateChirp(t, db, "kept", user.ID)
+
+	// A directory where the journal should be makes every append fail
+	if err := os.Remove(db.journalPath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(db.journalPath(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateChirp("lost", user.ID); err == nil {
+		t.Fatal("CreateChirp succeeded without writing the journal")
+	}
+	if _, err := db.UpdateUser(user.ID, "new@example.com", []byte("new hash")); err == nil {
+		t.Fatal("UpdateUser succeeded without writing the journal")
+	}
+	if _, err := db.LikeChirp(chirp.ID, user.ID); err == nil {
+		t.Fatal("LikeChirp succeeded without writing the journal")
+	}
+	page, err := db.QueryChirps(ChirpQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Chirps) != 1 || page.Chirps[0].ID != chirp.ID {
+		t.Fatalf("failed create left chirps %v behind", page.Chirps)
+	}
+	got, err := db.GetUserByEmail(user.Email)
+	if err != nil {
+		t.Fatalf("failed update lost the user: %s", err)
+	}
+	if string(got.Hash) != "hash" {
+		t.Fatalf("failed update left hash %q behind", got.Hash)
+	}
+	liked, err := db.GetChirp(chirp.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if liked.LikeCount != 0 {
+		t.Fatalf("failed like left %d likes behind", liked.LikeCount)
+	}
+
+	if err := os.Remove(db.journalPath()); err != nil {
+		t.Fatal(err)
+	}
+	retried := mustCreateChirp(t, db, "retried", user.ID)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := newJSONDB(Config{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	page, err = reopened.QueryChirps(ChirpQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Chirps) != 2 || page.Chirps[1].ID != retried.ID {
+		t.Fatalf("after reopening got chirps %v, want %q and %q", page.Chirps, "kept", "retried")
+	}
+}
@@ -1,33 +1,85 @@
 package database
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 func (db *DB) CreateUser(email string, hashedPassword []byte) (User, error) {
-	// Load the current database
-	dbStruct, err := db.loadDB()
+	user := User{}
+	err := db.Update(func(tx *Tx) error {
+		// Check if the user already exists
+		if _, ok := tx.UserByEmail(email); ok {
+			return ErrAlreadyExists
+		}
+		// Generate a unique ID for the user
+		id, err := tx.NextID("users")
+		if err != nil {
+			return err
+		}
+		// IsChirpyRed subscribed
+		subscribed := false
+		now := time.Now().UTC()
+		// Create the user
+		user = User{
+			ID:          id,
+			Email:       email,
+			Hash:        hashedPassword,
+			IsChirpyRed: subscribed,
+			Role:        RoleUser,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		return tx.PutUser(user)
+	})
 	if err != nil {
 		return User{}, err
 	}
-	// Check if the user already exists
-	existingUser, err := db.GetUserByEmail(email)
-	if err == nil && len(existingUser.Email) > 0 {
-		return User{}, ErrAlreadyExists
-	}
-	// Generate a unique ID for the user
-	id := len(dbStruct.Users) + 1
-	// IsChirpyRed subscribed
-	subscribed := false
-	// Create the user
-	user := User{
-		ID:          id,
-		Email:       email,
-		Hash:        hashedPassword,
-		IsChirpyRed: subscribed,
+
+	return user, nil
+}
+
+func (db *DB) GetUserByEmail(useremail string) (User, error) {
+	user := User{}
+	err := db.View(func(tx *Tx) error {
+		// Find the user
+		var ok bool
+		user, ok = tx.UserByEmail(useremail)
+		if !ok {
+			return errors.New("Could not find user")
+		}
+		return nil
+	})
+	if err != nil {
+		return User{}, err
 	}
-	// Add the user to the database
-	dbStruct.Users[id] = user
-	// Write the updated database back to disk
-	err = db.writeDB(dbStruct)
+	return user, nil
+}
+
+// UpdateUser sets a user's email and password. The tokens they hold stop
+// working and their sessions end, so that new credentials log out every
+// device.
+func (db *DB) UpdateUser(userIDInt int, email string, hashedPassword []byte) (User, error) {
+	user := User{}
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		user, ok = tx.User(userIDInt)
+		if !ok {
+			return errors.New("User does not exist")
+		}
+		// The new email can't be another user's
+		if existing, ok := tx.UserByEmail(email); ok && existing.ID != userIDInt {
+			return ErrAlreadyExists
+		}
+		user.Email = email
+		user.Hash = hashedPassword
+		user.TokenVersion++
+		user.UpdatedAt = time.Now().UTC()
+		if err := tx.PutUser(user); err != nil {
+			return err
+		}
+		return tx.DeleteUserSessions(userIDInt)
+	})
 	if err != nil {
 		return User{}, err
 	}
@@ -35,54 +87,148 @@ func (db *DB) CreateUser(email string, hashedPassword []byte) (User, error) {
 	return user, nil
 }
 
-func (db *DB) GetUserByEmail(useremail string) (User, error) {
-	// Load the current database
-	dbStruct, err := db.loadDB()
+func (db *DB) UpgradeUserStatus(userIDInt int) (User, error) {
+	user := User{}
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		user, ok = tx.User(userIDInt)
+		if !ok {
+			return errors.New("User does not exist")
+		}
+		user.IsChirpyRed = true
+		user.UpdatedAt = time.Now().UTC()
+		return tx.PutUser(user)
+	})
 	if err != nil {
 		return User{}, err
 	}
-	// Find the user
-	for _, eachUser := range dbStruct.Users {
-		if eachUser.Email == useremail {
-			return eachUser, nil
+	return user, nil
+}
+
+func (db *DB) GetUser(userID int) (User, error) {
+	user := User{}
+	err := db.View(func(tx *Tx) error {
+		var ok bool
+		user, ok = tx.User(userID)
+		if !ok {
+			return errors.New("User does not exist")
 		}
+		return nil
+	})
+	if err != nil {
+		return User{}, err
 	}
-	return User{}, errors.New("Could not find user")
+	return user, nil
 }
 
-func (db *DB) UpdateUser(userIDInt int, email string, hashedPassword []byte) (User, error) {
-	dbStruct, err := db.loadDB()
+func (db *DB) GetUserByHandle(handle string) (User, error) {
+	user := User{}
+	err := db.View(func(tx *Tx) error {
+		var ok bool
+		user, ok = tx.UserByHandle(handle)
+		if !ok {
+			return errors.New("Could not find user")
+		}
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
-	user, ok := dbStruct.Users[userIDInt]
-	if !ok {
-		return User{}, errors.New("User does not exist")
-	}
-	user.Email = email
-	user.Hash = hashedPassword
-	dbStruct.Users[userIDInt] = user
-	// Write the changes to disk
-	err = db.writeDB(dbStruct)
+	return user, nil
+}
+
+// UpdateProfile replaces the user's public profile. The handle must not
+// belong to anyone else.
+func (db *DB) UpdateProfile(userID int, profile Profile) (User, error) {
+	user := User{}
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		user, ok = tx.User(userID)
+		if !ok {
+			return errors.New("User does not exist")
+		}
+		if profile.Handle != "" {
+			if other, ok := tx.UserByHandle(profile.Handle); ok && other.ID != userID {
+				return ErrHandleTaken
+			}
+		}
+		user.Profile = profile
+		user.UpdatedAt = time.Now().UTC()
+		return tx.PutUser(user)
+	})
 	if err != nil {
 		return User{}, err
 	}
-
 	return user, nil
 }
 
-func (db *DB) UpgradeUserStatus(userIDInt int) (User, error) {
-	dbStruct, err := db.loadDB()
+// SuspendUser bars a user from logging in and chirping until
+// UnsuspendUser. Their sessions end and the tokens they hold stop working,
+// so that they can't carry on with a login from before. Suspending them
+// again changes nothing.
+func (db *DB) SuspendUser(userID int) (User, error) {
+	user := User{}
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		user, ok = tx.User(userID)
+		if !ok {
+			return errors.New("User does not exist")
+		}
+		if user.SuspendedAt != nil {
+			return nil
+		}
+		now := time.Now().UTC()
+		user.SuspendedAt = &now
+		user.TokenVersion++
+		user.UpdatedAt = now
+		if err := tx.PutUser(user); err != nil {
+			return err
+		}
+		return tx.DeleteUserSessions(userID)
+	})
 	if err != nil {
 		return User{}, err
 	}
-	user, ok := dbStruct.Users[userIDInt]
-	if !ok {
-		return User{}, errors.New("User does not exist")
+	return user, nil
+}
+
+func (db *DB) UnsuspendUser(userID int) (User, error) {
+	user := User{}
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		user, ok = tx.User(userID)
+		if !ok {
+			return errors.New("User does not exist")
+		}
+		if user.SuspendedAt == nil {
+			return nil
+		}
+		user.SuspendedAt = nil
+		user.UpdatedAt = time.Now().UTC()
+		return tx.PutUser(user)
+	})
+	if err != nil {
+		return User{}, err
 	}
-	user.IsChirpyRed = true
-	dbStruct.Users[userIDInt] = user
-	err = db.writeDB(dbStruct)
+	return user, nil
+}
+
+// SetUserRole gives a user one of the roles.
+func (db *DB) SetUserRole(userID int, role string) (User, error) {
+	user := User{}
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		user, ok = tx.User(userID)
+		if !ok {
+			return errors.New("User does not exist")
+		}
+		if user.Role == role {
+			return nil
+		}
+		user.Role = role
+		user.UpdatedAt = time.Now().UTC()
+		return tx.PutUser(user)
+	})
 	if err != nil {
 		return User{}, err
 	}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUpdateUserRejectsTakenEmail(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func() Store) {
+		db := open()
+		user := mustCreateUser(t, db, "user@example.com")
+		mustCreateUser(t, db, "other@example.com")
+
+		_, err := db.UpdateUser(user.ID, "other@example.com", []byte("new hash"))
+		if !errors.Is(err, ErrAlreadyExists) {
+			t.Fatalf("got error %v, want ErrAlreadyExists", err)
+		}
+		got, err := db.GetUserByEmail("user@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != user.ID || string(got.Hash) != "hash" || got.TokenVersion != user.TokenVersion {
+			t.Errorf("failed update changed the user: %+v", got)
+		}
+
+		// Keeping their own email is fine
+		updated, err := db.UpdateUser(user.ID, "user@example.com", []byte("new hash"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(updated.Hash) != "new hash" {
+			t.Errorf("got hash %q, want %q", updated.Hash, "new hash")
+		}
+	})
+}
+
+func TestSuspendUserEndsSessions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func() Store) {
+		db := open()
+		user := mustCreateUser(t, db, "user@example.com")
+		other := mustCreateUser(t, db, "other@example.com")
+		expires := time.Now().Add(time.Hour)
+		for i, session := range []Session{
+			{UserID: user.ID, TokenHash: "a", ExpiresAt: expires},
+			{UserID: user.ID, TokenHash: "b", ExpiresAt: expires},
+			{UserID: other.ID, TokenHash: "c", ExpiresAt: expires},
+		} {
+			if _, err := db.CreateSession(session); err != nil {
+				t.Fatalf("session %d: %s", i, err)
+			}
+		}
+
+		suspended, err := db.SuspendUser(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if suspended.SuspendedAt == nil {
+			t.Fatal("SuspendedAt not set")
+		}
+		if suspended.TokenVersion != user.TokenVersion+1 {
+			t.Errorf("got token version %d, want %d", suspended.TokenVersion, user.TokenVersion+1)
+		}
+		for _, hash := range []string{"a", "b"} {
+			if err := db.RevokeSession(hash); !errors.Is(err, ErrSessionNotFound) {
+				t.Errorf("session %s: got error %v, want ErrSessionNotFound", hash, err)
+			}
+		}
+		if err := db.RevokeSession("c"); err != nil {
+			t.Errorf("other user's session: %s", err)
+		}
+
+		// Suspending again changes nothing
+		again, err := db.SuspendUser(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.TokenVersion != suspended.TokenVersion || !again.SuspendedAt.Equal(*suspended.SuspendedAt) {
+			t.Errorf("suspending again changed the user: %+v, was %+v", again, suspended)
+		}
+	})
+}
+
+func TestUpdateUserEndsSessions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func() Store) {
+		db := open()
+		user := mustCreateUser(t, db, "user@example.com")
+		other := mustCreateUser(t, db, "other@example.com")
+		expires := time.Now().Add(time.Hour)
+		for i, session := range []Session{
+			{UserID: user.ID, TokenHash: "a", ExpiresAt: expires},
+			{UserID: user.ID, TokenHash: "b", ExpiresAt: expires},
+			{UserID: other.ID, TokenHash: "c", ExpiresAt: expires},
+		} {
+			if _, err := db.CreateSession(session); err != nil {
+				t.Fatalf("session %d: %s", i, err)
+			}
+		}
+
+		// A failed update leaves the sessions alone
+		if _, err := db.UpdateUser(user.ID, "other@example.com", []byte("new hash")); err == nil {
+			t.Fatal("took another user's email")
+		}
+		sessions, err := db.GetSessions(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sessions) != 2 {
+			t.Fatalf("failed update left %d sessions, want 2", len(sessions))
+		}
+
+		updated, err := db.UpdateUser(user.ID, "new@example.com", []byte("new hash"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.TokenVersion != user.TokenVersion+1 {
+			t.Errorf("got token version %d, want %d", updated.TokenVersion, user.TokenVersion+1)
+		}
+		sessions, err = db.GetSessions(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sessions) != 0 {
+			t.Errorf("updated user still has %d sessions", len(sessions))
+		}
+		sessions, err = db.GetSessions(other.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sessions) != 1 {
+			t.Errorf("other user has %d sessions, want 1", len(sessions))
+		}
+	})
+}
@@ -0,0 +1,269 @@
+// Package moderation checks chirp bodies against a list of words, each
+// with the action to take when it turns up: mask it, reject the chirp, or
+// flag the chirp for review.
+//
+// Words are matched whole, after normalizing both sides, so the rule for
+// "kerfuffle" also catches "Kerfuffle!", "KERFUFFLE", "kérfuffle" and the
+// full-width "ｋｅｒｆｕｆｆｌｅ", but not "kerfuffles".
+package moderation
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+type Action string
+
+const (
+	ActionMask   Action = "mask"
+	ActionReject Action = "reject"
+	ActionFlag   Action = "flag"
+)
+
+// mask replaces each masked word, whatever its length.
+const mask = "****"
+
+// Rule pairs a word with the action to take when a chirp contains it.
+type Rule struct {
+	Word   string
+	Action Action
+}
+
+// DefaultRules are the rules used when no word list is configured.
+var DefaultRules = []Rule{
+	{Word: "kerfuffle", Action: ActionMask},
+	{Word: "sharbert", Action: ActionMask},
+	{Word: "fornax", Action: ActionMask},
+}
+
+// Moderator checks text against a set of rules. It is safe for concurrent
+// use; Reload swaps the rules without disturbing checks in progress.
+type Moderator struct {
+	path  string
+	mux   sync.RWMutex
+	rules map[string]Action
+}
+
+// Match is a word of the text that a rule matched. Start and End are byte
+// offsets into the text that was checked.
+type Match struct {
+	Word   string
+	Action Action
+	Start  int
+	End    int
+}
+
+// Result is the outcome of checking a text.
+type Result struct {
+	// Text is the checked text with the masked words replaced
+	Text    string
+	Matches []Match
+}
+
+// Rejected reports whether a reject rule matched.
+func (res Result) Rejected() bool {
+	return res.has(ActionReject)
+}
+
+// Flagged reports whether a flag rule matched.
+func (res Result) Flagged() bool {
+	return res.has(ActionFlag)
+}
+
+func (res Result) has(action Action) bool {
+	for _, match := range res.Matches {
+		if match.Action == action {
+			return true
+		}
+	}
+	return false
+}
+
+// New returns a Moderator with a fixed set of rules.
+func New(rules []Rule) (*Moderator, error) {
+	m := &Moderator{}
+	if err := m.set(rules); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Load returns a Moderator with the rules in the word list at path; see
+// ParseRules for the format. Reload reads the file again.
+func Load(path string) (*Moderator, error) {
+	m := &Moderator{path: path}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload replaces the rules with the current contents of the word list. On
+// error the old rules stay in place. A Moderator made by New has no file
+// and keeps its rules.
+func (m *Moderator) Reload() error {
+	if m.path == "" {
+		return nil
+	}
+	file, err := os.Open(m.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rules, err := ParseRules(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", m.path, err)
+	}
+	return m.set(rules)
+}
+
+func (m *Moderator) set(rules []Rule) error {
+	words := make(map[string]Action, len(rules))
+	for _, rule := range rules {
+		switch rule.Action {
+		case ActionMask, ActionReject, ActionFlag:
+		default:
+			return fmt.Errorf("unknown action %q for %q", rule.Action, rule.Word)
+		}
+		word := Normalize(rule.Word)
+		tokens := tokenize(word)
+		if len(tokens) != 1 || tokens[0].text != word {
+			return fmt.Errorf("%q is not a single word", rule.Word)
+		}
+		// The strictest action wins when a word is listed twice
+		if severity(rule.Action) > severity(words[word]) {
+			words[word] = rule.Action
+		}
+	}
+
+	m.mux.Lock()
+	m.rules = words
+	m.mux.Unlock()
+	return nil
+}
+
+func severity(action Action) int {
+	switch action {
+	case ActionMask:
+		return 1
+	case ActionFlag:
+		return 2
+	case ActionReject:
+		return 3
+	}
+	return 0
+}
+
+// Check matches the words of text against the rules and masks the words
+// whose rule says so.
+func (m *Moderator) Check(text string) Result {
+	m.mux.RLock()
+	rules := m.rules
+	m.mux.RUnlock()
+
+	res := Result{}
+	var cleaned strings.Builder
+	last := 0
+	for _, token := range tokenize(text) {
+		word := Normalize(token.text)
+		action, ok := rules[word]
+		if !ok {
+			continue
+		}
+		res.Matches = append(res.Matches, Match{
+			Word:   word,
+			Action: action,
+			Start:  token.start,
+			End:    token.end,
+		})
+		if action == ActionMask {
+			cleaned.WriteString(text[last:token.start])
+			cleaned.WriteString(mask)
+			last = token.end
+		}
+	}
+	cleaned.WriteString(text[last:])
+	res.Text = cleaned.String()
+	return res
+}
+
+// ParseRules reads a word list. Each line holds an action and a word,
+// separated by spaces; blank lines and lines starting with # are ignored.
+//
+//	# masked as ****
+//	mask kerfuffle
+//	reject fornax
+//	flag sharbert
+func ParseRules(r io.Reader) ([]Rule, error) {
+	rules := []Rule{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: want an action and a word", line)
+		}
+		rules = append(rules, Rule{Word: fields[1], Action: Action(fields[0])})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Normalize puts a word in the form rules are matched in: compatibility
+// characters such as full-width letters replaced by their plain forms,
+// accents and other combining marks and invisible format characters
+// removed, and everything in lower case.
+func Normalize(word string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(word) {
+		if unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Cf, r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+type token struct {
+	text       string
+	start, end int
+}
+
+// tokenize splits text into words: runs of letters, digits and marks.
+// Everything else, punctuation included, separates words, except format
+// characters such as zero-width spaces, which could otherwise be used to
+// split a word invisibly.
+func tokenize(text string) []token {
+	tokens := []token{}
+	start := -1
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.M, r) ||
+			(start >= 0 && unicode.Is(unicode.Cf, r))
+		if inWord && start < 0 {
+			start = i
+		} else if !inWord && start >= 0 {
+			tokens = append(tokens, token{text: text[start:i], start: start, end: i})
+			start = -1
+		}
+		i += size
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{text: text[start:], start: start, end: len(text)})
+	}
+	return tokens
+}
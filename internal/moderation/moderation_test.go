@@ -0,0 +1,296 @@
+package moderation
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"kerfuffle", "kerfuffle"},
+		{"KERFUFFLE", "kerfuffle"},
+		{"Kérfuffle", "kerfuffle"},
+		{"ke\u0301rfuffle", "kerfuffle"},
+		{"ｋｅｒｆｕｆｆｌｅ", "kerfuffle"},
+		{"ker\u200bfuffle", "kerfuffle"},
+		{"ﬁne", "fine"},
+		{"Ärger", "arger"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.word); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []token
+	}{
+		{"", []token{}},
+		{"  ", []token{}},
+		{"hello", []token{{"hello", 0, 5}}},
+		{"Kerfuffle!", []token{{"Kerfuffle", 0, 9}}},
+		{"fornax\n", []token{{"fornax", 0, 6}}},
+		{"one, two...three", []token{{"one", 0, 3}, {"two", 5, 8}, {"three", 11, 16}}},
+		{"it's", []token{{"it", 0, 2}, {"s", 3, 4}}},
+		{"route66", []token{{"route66", 0, 7}}},
+		{"ｋｅｒｆｕｆｆｌｅ。", []token{{"ｋｅｒｆｕｆｆｌｅ", 0, 27}}},
+		{"ke\u0301rfuffle", []token{{"ke\u0301rfuffle", 0, 11}}},
+		// A zero-width space only joins inside a word
+		{"ker\u200bfuffle", []token{{"ker\u200bfuffle", 0, 12}}},
+		{"\u200bfornax", []token{{"fornax", 3, 9}}},
+	}
+	for _, tt := range tests {
+		if got := tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []Rule
+		wantErr string
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  []Rule{},
+		},
+		{
+			name:  "rules, comments and blank lines",
+			input: "# masked as ****\nmask kerfuffle\n\n  reject   fornax  \n\tflag sharbert\n",
+			want: []Rule{
+				{Word: "kerfuffle", Action: ActionMask},
+				{Word: "fornax", Action: ActionReject},
+				{Word: "sharbert", Action: ActionFlag},
+			},
+		},
+		{
+			name:  "no trailing newline",
+			input: "mask kerfuffle",
+			want:  []Rule{{Word: "kerfuffle", Action: ActionMask}},
+		},
+		{
+			name:    "missing word",
+			input:   "mask kerfuffle\nreject\n",
+			wantErr: "line 2",
+		},
+		{
+			name:    "too many fields",
+			input:   "# comment\nmask two words\n",
+			wantErr: "line 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRules(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRejectsBadRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{"unknown action", Rule{Word: "kerfuffle", Action: "delete"}},
+		{"two words", Rule{Word: "ker fuffle", Action: ActionMask}},
+		{"punctuation", Rule{Word: "kerfuffle!", Action: ActionMask}},
+		{"empty word", Rule{Word: "", Action: ActionMask}},
+	}
+	for _, tt := range tests {
+		if _, err := New([]Rule{tt.rule}); err == nil {
+			t.Errorf("%s: New(%v) succeeded", tt.name, tt.rule)
+		}
+	}
+}
+
+func TestCheckDefaultRules(t *testing.T) {
+	m, err := New(DefaultRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"I had something interesting for breakfast", "I had something interesting for breakfast"},
+		{"I hear Mastodon is better than Chirpy. sharbert I need to migrate", "I hear Mastodon is better than Chirpy. **** I need to migrate"},
+		{"I really need a kerfuffle to go to bed sooner, Fornax !", "I really need a **** to go to bed sooner, **** !"},
+		{"Kerfuffle!", "****!"},
+		{"fornax\n", "****\n"},
+		{"SHARBERT, kerfuffle; fornax.", "****, ****; ****."},
+		{"Kérfuffle", "****"},
+		{"ke\u0301rfuffle", "****"},
+		{"ｋｅｒｆｕｆｆｌｅ。", "****。"},
+		{"ＦＯＲＮＡＸ", "****"},
+		{"ker\u200bfuffle", "****"},
+		// Only whole words match
+		{"kerfuffles", "kerfuffles"},
+		{"superfornax", "superfornax"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		res := m.Check(tt.text)
+		if res.Text != tt.want {
+			t.Errorf("Check(%q).Text = %q, want %q", tt.text, res.Text, tt.want)
+		}
+		if res.Rejected() || res.Flagged() {
+			t.Errorf("Check(%q) rejected or flagged with mask-only rules", tt.text)
+		}
+	}
+}
+
+func TestCheckActions(t *testing.T) {
+	m, err := New([]Rule{
+		{Word: "kerfuffle", Action: ActionMask},
+		{Word: "fornax", Action: ActionReject},
+		{Word: "sharbert", Action: ActionFlag},
+		// The strictest action wins
+		{Word: "bingle", Action: ActionFlag},
+		{Word: "Bingle", Action: ActionMask},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		text         string
+		wantText     string
+		wantRejected bool
+		wantFlagged  bool
+		wantMatches  []Match
+	}{
+		{
+			text:     "all fine",
+			wantText: "all fine",
+		},
+		{
+			text:        "a kerfuffle",
+			wantText:    "a ****",
+			wantMatches: []Match{{Word: "kerfuffle", Action: ActionMask, Start: 2, End: 11}},
+		},
+		{
+			text:         "Fornax?",
+			wantText:     "Fornax?",
+			wantRejected: true,
+			wantMatches:  []Match{{Word: "fornax", Action: ActionReject, Start: 0, End: 6}},
+		},
+		{
+			text:        "sharbert and kerfuffle",
+			wantText:    "sharbert and ****",
+			wantFlagged: true,
+			wantMatches: []Match{
+				{Word: "sharbert", Action: ActionFlag, Start: 0, End: 8},
+				{Word: "kerfuffle", Action: ActionMask, Start: 13, End: 22},
+			},
+		},
+		{
+			text:        "BINGLE",
+			wantText:    "BINGLE",
+			wantFlagged: true,
+			wantMatches: []Match{{Word: "bingle", Action: ActionFlag, Start: 0, End: 6}},
+		},
+	}
+	for _, tt := range tests {
+		res := m.Check(tt.text)
+		if res.Text != tt.wantText {
+			t.Errorf("Check(%q).Text = %q, want %q", tt.text, res.Text, tt.wantText)
+		}
+		if res.Rejected() != tt.wantRejected {
+			t.Errorf("Check(%q).Rejected() = %t, want %t", tt.text, res.Rejected(), tt.wantRejected)
+		}
+		if res.Flagged() != tt.wantFlagged {
+			t.Errorf("Check(%q).Flagged() = %t, want %t", tt.text, res.Flagged(), tt.wantFlagged)
+		}
+		if !reflect.DeepEqual(res.Matches, tt.wantMatches) {
+			t.Errorf("Check(%q).Matches = %v, want %v", tt.text, res.Matches, tt.wantMatches)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	write := func(contents string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(m *Moderator, text string, want string) {
+		t.Helper()
+		if got := m.Check(text).Text; got != want {
+			t.Errorf("Check(%q).Text = %q, want %q", text, got, want)
+		}
+	}
+
+	write("mask kerfuffle\n")
+	m, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(m, "kerfuffle fornax", "**** fornax")
+
+	write("mask fornax\n")
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	check(m, "kerfuffle fornax", "kerfuffle ****")
+
+	// A list that doesn't parse leaves the old rules in place
+	for _, bad := range []string{"mask\n", "erase fornax\n", "mask two words\n"} {
+		write(bad)
+		if err := m.Reload(); err == nil {
+			t.Errorf("Reload succeeded with list %q", bad)
+		}
+		check(m, "kerfuffle fornax", "kerfuffle ****")
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reload(); err == nil {
+		t.Error("Reload succeeded without the list")
+	}
+	check(m, "kerfuffle fornax", "kerfuffle ****")
+
+	if _, err := Load(path); err == nil {
+		t.Error("Load succeeded without the list")
+	}
+}
+
+func TestReloadWithoutFile(t *testing.T) {
+	m, err := New(DefaultRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Check("fornax").Text; got != "****" {
+		t.Errorf("rules changed on Reload: Check(%q).Text = %q", "fornax", got)
+	}
+}
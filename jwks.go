@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// handlerJWKS publishes the public keys that tokens are signed with, so
+// that other services can verify them without holding any secret.
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, cfg.jwtKeys.JWKS())
+}
+
+// rotateJWTKeys checks every interval whether the signing key is due to be
+// replaced, and picks up keys that were added to or removed from the key
+// directory. It returns when ctx is cancelled.
+func (cfg *apiConfig) rotateJWTKeys(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := cfg.jwtKeys.Rotate()
+			if err != nil {
+				log.Printf("Error rotating JWT keys: %s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -1,23 +1,33 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/tcluri/chirpy/internal/auth"
 	"github.com/tcluri/chirpy/internal/database"
+	"github.com/tcluri/chirpy/internal/moderation"
 
 	"github.com/go-chi/chi/v5"
 )
 
 type apiConfig struct {
 	fileserverHits int
-	DB             *database.DB
-	jwtSecret      string
+	DB             database.Store
+	jwtKeys        *auth.KeySet
 	polkaSecret    string
+	chirpRetention time.Duration
+	moderator      *moderation.Moderator
+	adminKey       string
 }
 
 func main() {
@@ -27,9 +37,12 @@ func main() {
 	// Load the environment variable
 	godotenv.Load(".env")
 
+	// Tokens are signed with the keys in JWT_KEYS_DIR if it is set, and
+	// with JWT_SECRET otherwise
 	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable not set")
+	jwtKeysDir := os.Getenv("JWT_KEYS_DIR")
+	if jwtSecret == "" && jwtKeysDir == "" {
+		log.Fatal("JWT_SECRET or JWT_KEYS_DIR environment variable not set")
 	}
 
 	polkaKey := os.Getenv("POLKA_KEY")
@@ -37,10 +50,74 @@ func main() {
 		log.Fatal("POLKA_KEY environment variable not set")
 	}
 
+	chirpRetention := 30 * 24 * time.Hour
+	if retention := os.Getenv("CHIRP_RETENTION"); retention != "" {
+		d, err := time.ParseDuration(retention)
+		if err != nil {
+			log.Fatalf("Invalid CHIRP_RETENTION: %s", err)
+		}
+		chirpRetention = d
+	}
+
+	tokenPurgeInterval := time.Hour
+	if interval := os.Getenv("TOKEN_PURGE_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid TOKEN_PURGE_INTERVAL: %s", interval)
+		}
+		tokenPurgeInterval = d
+	}
+
+	moderator, err := moderation.New(moderation.DefaultRules)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if wordsFile := os.Getenv("MODERATION_WORDS_FILE"); wordsFile != "" {
+		moderator, err = moderation.Load(wordsFile)
+		if err != nil {
+			log.Fatalf("Couldn't load moderation words: %s", err)
+		}
+	}
+
+	keyConfig := auth.KeyConfig{
+		Dir:       jwtKeysDir,
+		Algorithm: os.Getenv("JWT_KEY_ALGORITHM"),
+		Retention: refreshExpiry,
+		Secret:    jwtSecret,
+	}
+	if rotation := os.Getenv("JWT_KEY_ROTATION"); rotation != "" {
+		d, err := time.ParseDuration(rotation)
+		if err != nil || d < 0 {
+			log.Fatalf("Invalid JWT_KEY_ROTATION: %s", rotation)
+		}
+		keyConfig.Rotation = d
+	}
+	jwtKeys, err := auth.NewKeySet(keyConfig)
+	if err != nil {
+		log.Fatalf("Couldn't load JWT keys: %s", err)
+	}
+
 	// Welcome message
 	fmt.Println("Hello! Welcome to the chirpy webserver!")
 
-	db, err := database.NewDB("database.json")
+	dbConfig := database.Config{
+		Driver: os.Getenv("DB_DRIVER"),
+		Path:   os.Getenv("DB_PATH"),
+	}
+	if flushInterval := os.Getenv("DB_FLUSH_INTERVAL"); flushInterval != "" {
+		interval, err := time.ParseDuration(flushInterval)
+		if err != nil {
+			log.Fatalf("Invalid DB_FLUSH_INTERVAL: %s", err)
+		}
+		dbConfig.FlushInterval = interval
+	}
+	if dbConfig.Path == "" {
+		dbConfig.Path = "database.json"
+		if dbConfig.Driver == database.DriverSQLite {
+			dbConfig.Path = "database.db"
+		}
+	}
+	db, err := database.Open(dbConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -58,43 +135,115 @@ func main() {
 	apiCfg := apiConfig{
 		fileserverHits: 0,
 		DB:             db,
-		jwtSecret:      jwtSecret,
+		jwtKeys:        jwtKeys,
 		polkaSecret:    polkaKey,
+		chirpRetention: chirpRetention,
+		moderator:      moderator,
+		adminKey:       os.Getenv("ADMIN_KEY"),
+	}
+
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: newRouter(&apiCfg),
+	}
+	// Stop accepting requests and flush the database on Ctrl-C or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	go apiCfg.purgeDeletedChirps(ctx, time.Hour)
+	go apiCfg.purgeExpiredTokens(ctx, tokenPurgeInterval)
+	go apiCfg.reloadModerationOnHangup(ctx)
+	if jwtKeysDir != "" {
+		go apiCfg.rotateJWTKeys(ctx, time.Hour)
+	}
+
+	log.Printf("Serving files from %s on port %s\n", filepathRoot, port)
+	err = srv.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+
+	log.Println("Shutting down")
+	err = db.Close()
+	if err != nil {
+		log.Fatal(err)
 	}
+}
+
+// newRouter routes the app and the API to the handlers of apiCfg.
+func newRouter(apiCfg *apiConfig) http.Handler {
 	// mux := http.NewServeMux()
 
 	router := chi.NewRouter() // app router
 	// fsHandler := apiCfg.middlewareMetricsInc(middlewareLog(http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))))
 
+	router.Get("/.well-known/jwks.json", apiCfg.handlerJWKS)
 	router.Mount("/", apiCfg.middlewareMetricsInc(middlewareLog(http.FileServer(http.Dir(".")))))
 
-	router.Get("/metrics", apiCfg.handlerMetrics)
-
 	// API router endpoints
 	apiRouter := chi.NewRouter() // api router
+	// Routes that need an access token, and ones that can take one
+	authed := apiRouter.With(apiCfg.middlewareAuth)
+	optionalAuth := apiRouter.With(apiCfg.middlewareOptionalAuth)
 	apiRouter.Get("/healthz", handlerReadiness)
 
-	apiRouter.Post("/chirps", apiCfg.handlerChirpsCreate)
+	authed.Post("/chirps", apiCfg.handlerChirpsCreate)
 	apiRouter.Get("/chirps", apiCfg.handlerChirpsRetrieve)
+	apiRouter.Get("/chirps/search", apiCfg.handlerChirpsSearch)
 	apiRouter.Get("/chirps/{chirpID}", apiCfg.handlerChirpsGet)
-	apiRouter.Delete("/chirps/{chirpID}", apiCfg.handlerChirpsDelete)
-
-	apiRouter.Put("/users", apiCfg.handlerUsersUpdate)
+	authed.Put("/chirps/{chirpID}", apiCfg.handlerChirpsUpdate)
+	apiRouter.Get("/chirps/{chirpID}/revisions", apiCfg.handlerChirpsRevisions)
+	apiRouter.Get("/chirps/{chirpID}/thread", apiCfg.handlerChirpsThread)
+	authed.Delete("/chirps/{chirpID}", apiCfg.handlerChirpsDelete)
+	authed.Post("/chirps/{chirpID}/restore", apiCfg.handlerChirpsRestore)
+	authed.Post("/chirps/{chirpID}/like", apiCfg.handlerChirpsLike)
+	authed.Delete("/chirps/{chirpID}/like", apiCfg.handlerChirpsUnlike)
+	authed.Post("/chirps/{chirpID}/report", apiCfg.handlerChirpsReport)
+
+	authed.Put("/users", apiCfg.handlerUsersUpdate)
 	apiRouter.Post("/users", apiCfg.handlerUsersCreate)
+	authed.Put("/users/profile", apiCfg.handlerUsersUpdateProfile)
+	optionalAuth.Get("/users/{userID}", apiCfg.handlerUsersGet)
+	optionalAuth.Get("/users/by-handle/{handle}", apiCfg.handlerUsersGetByHandle)
+	apiRouter.Get("/users/{userID}/likes", apiCfg.handlerUsersLikes)
+	apiRouter.Get("/users/{userID}/mentions", apiCfg.handlerUsersMentions)
+	authed.Post("/users/{userID}/follow", apiCfg.handlerUsersFollow)
+	authed.Delete("/users/{userID}/follow", apiCfg.handlerUsersUnfollow)
+	apiRouter.Get("/users/{userID}/followers", apiCfg.handlerUsersFollowers)
+	apiRouter.Get("/users/{userID}/following", apiCfg.handlerUsersFollowing)
+	authed.Get("/timeline", apiCfg.handlerTimeline)
+	apiRouter.Get("/hashtags/trending", apiCfg.handlerHashtagsTrending)
+	apiRouter.Get("/hashtags/{tag}/chirps", apiCfg.handlerHashtagChirps)
 
 	apiRouter.Post("/polka/webhooks", apiCfg.handlerUserUpgrade)
 
 	apiRouter.Post("/refresh", apiCfg.handlerRefresh)
 	apiRouter.Post("/revoke", apiCfg.handlerRevoke)
+	authed.Get("/sessions", apiCfg.handlerSessionsList)
+	authed.Delete("/sessions", apiCfg.handlerSessionsDeleteAll)
+	authed.Delete("/sessions/{sessionID}", apiCfg.handlerSessionsDelete)
 	apiRouter.Post("/login", apiCfg.handlerUsersLogin)
 	router.Mount("/api", middlewareLog(apiRouter))
 
-	corsMux := middlewareCors(router)
-
-	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: corsMux,
-	}
-	log.Printf("Serving files from %s on port %s\n", filepathRoot, port)
-	log.Fatal(srv.ListenAndServe())
+	// Admin router endpoints, each open to the roles it names
+	adminRouter := chi.NewRouter()
+	moderators := adminRouter.With(apiCfg.middlewareRequireRole(database.RoleModerator, database.RoleAdmin))
+	moderators.Get("/reports", apiCfg.handlerAdminReports)
+	moderators.Post("/reports/{reportID}/resolve", apiCfg.handlerAdminReportsResolve)
+	moderators.Post("/chirps/{chirpID}/hide", apiCfg.handlerAdminChirpsHide)
+	moderators.Delete("/chirps/{chirpID}/hide", apiCfg.handlerAdminChirpsUnhide)
+	moderators.Post("/users/{userID}/suspend", apiCfg.handlerAdminUsersSuspend)
+	moderators.Delete("/users/{userID}/suspend", apiCfg.handlerAdminUsersUnsuspend)
+	admins := adminRouter.With(apiCfg.middlewareRequireRole(database.RoleAdmin))
+	admins.Get("/metrics", apiCfg.handlerMetrics)
+	admins.Put("/users/{userID}/role", apiCfg.handlerAdminUsersRole)
+	router.Mount("/admin", middlewareLog(adminRouter))
+
+	return middlewareCors(router)
 }
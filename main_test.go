@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tcluri/chirpy/internal/auth"
+	"github.com/tcluri/chirpy/internal/database"
+	"github.com/tcluri/chirpy/internal/moderation"
+)
+
+func TestMain(m *testing.M) {
+	// Keep the request log out of the test output
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+const testAdminKey = "test admin key"
+
+// forEachDriver runs test against a server on a fresh JSON store and one on
+// a fresh SQLite store.
+func forEachDriver(t *testing.T, test func(t *testing.T, srv *httptest.Server)) {
+	for _, driver := range []string{database.DriverJSON, database.DriverSQLite} {
+		driver := driver
+		t.Run(driver, func(t *testing.T) {
+			db, err := database.Open(database.Config{
+				Driver: driver,
+				Path:   filepath.Join(t.TempDir(), "db"),
+			})
+			if err != nil {
+				t.Fatalf("opening %s store: %s", driver, err)
+			}
+			t.Cleanup(func() { db.Close() })
+			moderator, err := moderation.New(moderation.DefaultRules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jwtKeys, err := auth.NewKeySet(auth.KeyConfig{Secret: "test secret"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := &apiConfig{
+				DB:          db,
+				jwtKeys:     jwtKeys,
+				polkaSecret: "test key",
+				adminKey:    testAdminKey,
+				moderator:   moderator,
+			}
+			srv := httptest.NewServer(newRouter(cfg))
+			t.Cleanup(srv.Close)
+			test(t, srv)
+		})
+	}
+}
+
+// doJSON sends body as JSON, with token as a bearer token unless it is
+// empty or an API key ("ApiKey {key}"), and decodes the response into out
+// unless it is nil.
+func doJSON(srv *httptest.Server, method, path, token string, body, out any) (int, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	if strings.HasPrefix(token, "ApiKey ") {
+		req.Header.Set("Authorization", token)
+	} else if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("%s %s: %w", method, path, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// expectStatus is doJSON failing unless the response has the given status.
+func expectStatus(want int, srv *httptest.Server, method, path, token string, body, out any) error {
+	status, err := doJSON(srv, method, path, token, body, out)
+	if err != nil {
+		return err
+	}
+	if status != want {
+		return fmt.Errorf("%s %s: got status %d, want %d", method, path, status, want)
+	}
+	return nil
+}
+
+// parallel runs fn(i) for i in [0, n) on n goroutines released at the same
+// moment, and reports the first error any of them returned.
+func parallel(t *testing.T, n int, fn func(i int) error) {
+	t.Helper()
+	start := make(chan struct{})
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs <- fn(i)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	// Every new user costs a bcrypt hash, so keep the crowd small
+	const workers = 8
+	forEachDriver(t, func(t *testing.T, srv *httptest.Server) {
+		type credentials struct {
+			Email    string `json:"email"`
+			Password string `json:"password"`
+		}
+
+		var mux sync.Mutex
+		userIDs := map[int]bool{}
+		parallel(t, workers, func(i int) error {
+			user := User{}
+			err := expectStatus(http.StatusCreated, srv, "POST", "/api/users", "",
+				credentials{Email: fmt.Sprintf("user%d@example.com", i), Password: "password"}, &user)
+			if err != nil {
+				return err
+			}
+			mux.Lock()
+			defer mux.Unlock()
+			if userIDs[user.ID] {
+				return fmt.Errorf("user ID %d handed out twice", user.ID)
+			}
+			userIDs[user.ID] = true
+			return nil
+		})
+
+		login := struct {
+			Token string `json:"token"`
+		}{}
+		err := expectStatus(http.StatusOK, srv, "POST", "/api/login", "",
+			credentials{Email: "user0@example.com", Password: "password"}, &login)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		chirpIDs := map[int]bool{}
+		parallel(t, workers, func(i int) error {
+			chirp := Chirp{}
+			err := expectStatus(http.StatusCreated, srv, "POST", "/api/chirps", login.Token,
+				map[string]string{"body": fmt.Sprintf("chirp %d", i)}, &chirp)
+			if err != nil {
+				return err
+			}
+			mux.Lock()
+			defer mux.Unlock()
+			if chirpIDs[chirp.ID] {
+				return fmt.Errorf("chirp ID %d handed out twice", chirp.ID)
+			}
+			chirpIDs[chirp.ID] = true
+			return nil
+		})
+
+		chirps := []Chirp{}
+		err = expectStatus(http.StatusOK, srv, "GET", "/api/chirps", "", nil, &chirps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chirps) != workers {
+			t.Fatalf("got %d chirps, want %d", len(chirps), workers)
+		}
+	})
+}
@@ -6,6 +6,11 @@ import (
 )
 
 func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
+	tokenStats, err := cfg.DB.GetTokenStats()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count tokens")
+		return
+	}
 	w.Header().Add("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(fmt.Sprintf(`
@@ -14,11 +19,13 @@ func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
 <body>
 	<h1>Welcome, Chirpy Admin</h1>
 	<p>Chirpy has been visited %d times!</p>
+	<p>Sessions stored: %d</p>
+	<p>Revoked refresh tokens stored: %d</p>
 </body>
 
 </html>
 
-	`, cfg.fileserverHits)))
+	`, cfg.fileserverHits, tokenStats.Sessions, tokenStats.RevokedTokens)))
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
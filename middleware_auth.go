@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/tcluri/chirpy/internal/auth"
+)
+
+// Principal is who a request is made by, as established by the auth
+// middleware. Role is the user's role as it is now, not the one their
+// token was issued with.
+type Principal struct {
+	UserID int
+	Role   string
+}
+
+type principalContextKey struct{}
+
+// principalFromContext returns the principal the auth middleware stored in
+// ctx. It reports false for anonymous requests on optional-auth routes.
+func principalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(Principal)
+	return principal, ok
+}
+
+func contextWithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+var errNoCredentials = errors.New("Couldn't find JWT")
+
+var errUserSuspended = errors.New("User is suspended")
+
+// authenticate reads the principal from the access token in the request's
+// Authorization header. It returns errNoCredentials when there is no token,
+// and errUserSuspended for the tokens of suspended users.
+func (cfg *apiConfig) authenticate(r *http.Request) (Principal, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return Principal{}, errNoCredentials
+	}
+	claims, account, err := auth.ValidateJWTClaims(token, cfg.jwtKeys, cfg.lookupAccount)
+	if errors.Is(err, errUserSuspended) {
+		return Principal{}, err
+	}
+	if err != nil {
+		return Principal{}, errors.New("Couldn't validate JWT")
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return Principal{}, errors.New("Couldn't validate JWT")
+	}
+	return Principal{UserID: userID, Role: account.Role}, nil
+}
+
+// lookupAccount gives the auth package what it needs to know about a user
+// to check their tokens. The tokens of suspended users are refused with
+// errUserSuspended.
+func (cfg *apiConfig) lookupAccount(userID int) (auth.Account, error) {
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		return auth.Account{}, err
+	}
+	if user.SuspendedAt != nil {
+		return auth.Account{}, errUserSuspended
+	}
+	return auth.Account{Role: user.Role, TokenVersion: user.TokenVersion}, nil
+}
+
+// middlewareAuth only lets requests with a valid access token through, with
+// their principal in the request context.
+func (cfg *apiConfig) middlewareAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := cfg.authenticate(r)
+		if err != nil {
+			respondAuthError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(contextWithPrincipal(r.Context(), principal)))
+	})
+}
+
+// middlewareOptionalAuth is middlewareAuth for routes that anyone can use.
+// Requests without an Authorization header go through anonymously, but a
+// token that is sent has to be valid, so that clients find out when theirs
+// has expired.
+func (cfg *apiConfig) middlewareOptionalAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := cfg.authenticate(r)
+		if errors.Is(err, errNoCredentials) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			respondAuthError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(contextWithPrincipal(r.Context(), principal)))
+	})
+}
+
+// respondAuthError responds to a request that authenticate turned down:
+// with 403 for suspended users, whose tokens are fine but who may not use
+// them, and with respondUnauthorized otherwise.
+func respondAuthError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUserSuspended) {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	respondUnauthorized(w, err)
+}
+
+// respondUnauthorized responds with 401 and a WWW-Authenticate challenge,
+// which names the error, as RFC 6750 asks, when a token was sent.
+func respondUnauthorized(w http.ResponseWriter, err error) {
+	challenge := `Bearer realm="chirpy"`
+	if !errors.Is(err, errNoCredentials) {
+		challenge += `, error="invalid_token"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	respondWithError(w, http.StatusUnauthorized, err.Error())
+}
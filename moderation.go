@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reloadModerationOnHangup reloads the moderation word list whenever the
+// process receives SIGHUP, so the list can be changed without a restart.
+// It returns when ctx is cancelled.
+func (cfg *apiConfig) reloadModerationOnHangup(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	for {
+		select {
+		case <-hangup:
+			if err := cfg.moderator.Reload(); err != nil {
+				log.Printf("Error reloading moderation words: %s", err)
+				continue
+			}
+			log.Println("Reloaded moderation words")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// purgeDeletedChirps permanently removes, every interval, the chirps that
+// have been deleted for longer than the restore window. It returns when ctx
+// is cancelled.
+func (cfg *apiConfig) purgeDeletedChirps(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			deletedBefore := time.Now().UTC().Add(-cfg.chirpRetention)
+			purged, err := cfg.DB.PurgeDeletedChirps(deletedBefore)
+			if err != nil {
+				log.Printf("Error purging deleted chirps: %s", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("Purged %d deleted chirps", purged)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// purgeExpiredTokens removes, every interval, the sessions and revoked
+// refresh tokens that have expired. It returns when ctx is cancelled.
+func (cfg *apiConfig) purgeExpiredTokens(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			purged, err := cfg.DB.PurgeExpiredTokens(time.Now().UTC())
+			if err != nil {
+				log.Printf("Error purging expired tokens: %s", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("Purged %d expired sessions and revoked tokens", purged)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}